@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	mgo "gopkg.in/mgo.v2"
+)
+
+const (
+	alertTypeReviewVolume   = "review_volume"
+	alertTypeRatingDrop     = "rating_drop"
+	alertTypePageRatingDrop = "page_rating_drop"
+
+	defaultAlertEvaluationInterval = time.Hour
+	day                            = int64(24 * 60 * 60)
+)
+
+// alertRuleDefaults holds the defaults of the rule fields left empty by the client
+var alertRuleDefaults = map[string]AlertRuleGooglePlay{
+	alertTypeReviewVolume:   {Class: reviewClassBugReport, Threshold: 2, MinCount: 5, WindowDays: 1, BaselineDays: 7},
+	alertTypeRatingDrop:     {Threshold: 1, MinCount: 5, WindowDays: 1, BaselineDays: 7},
+	alertTypePageRatingDrop: {Threshold: 0.2, WindowDays: 1, BaselineDays: 7},
+}
+
+// isValidAlertType returns true if alert rules of the given type can be evaluated
+func isValidAlertType(ruleType string) bool {
+	_, ok := alertRuleDefaults[ruleType]
+	return ok
+}
+
+// applyAlertRuleDefaults fills the empty fields of a rule with the defaults of its type
+func applyAlertRuleDefaults(rule AlertRuleGooglePlay) AlertRuleGooglePlay {
+	defaults := alertRuleDefaults[rule.Type]
+	if rule.Class == "" {
+		rule.Class = defaults.Class
+	}
+	if rule.Threshold == 0 {
+		rule.Threshold = defaults.Threshold
+	}
+	if rule.MinCount == 0 {
+		rule.MinCount = defaults.MinCount
+	}
+	if rule.WindowDays == 0 {
+		rule.WindowDays = defaults.WindowDays
+	}
+	if rule.BaselineDays == 0 {
+		rule.BaselineDays = defaults.BaselineDays
+	}
+
+	return rule
+}
+
+// runAlertEvaluator evaluates the alert rules periodically until stop is closed
+func runAlertEvaluator(mongoClient *mgo.Session, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		forEachTenant(mongoClient, func(m *storageSession) {
+			evaluateAlertRules(m, time.Now())
+		})
+	}
+}
+
+// evaluateAlertRules checks all alert rules at the given time, stores and delivers the triggered alerts.
+// A rule triggers at most once per window.
+func evaluateAlertRules(mongoClient *storageSession, now time.Time) []AlertGooglePlay {
+	alerts := []AlertGooglePlay{}
+	for _, rule := range MongoGetAlertRulesGooglePlay(mongoClient, "") {
+		rule = applyAlertRuleDefaults(rule)
+		if rule.LastTriggered > now.Unix()-int64(rule.WindowDays)*day {
+			continue
+		}
+
+		alert, triggered := evaluateAlertRule(mongoClient, rule, now)
+		if !triggered {
+			continue
+		}
+		if rule.WebhookURL != "" {
+			err := postWebhook(rule.WebhookURL, alert)
+			if err != nil {
+				loggerFrom(mongoClient.ctx).Error("could not deliver alert", "package_name", rule.PackageName, "error", err)
+			}
+			alert.Delivered = err == nil
+		}
+		MongoInsertAlertGooglePlay(mongoClient, alert)
+		MongoSetAlertRuleLastTriggered(mongoClient, rule, alert.TriggeredAt)
+		alerts = append(alerts, alert)
+	}
+
+	return alerts
+}
+
+// evaluateAlertRule compares the current window of a rule, its last days up to and including today, against the
+// baseline window of the days before. The windows are dates like 20191101 like the dates of the reviews and pages.
+func evaluateAlertRule(mongoClient *storageSession, rule AlertRuleGooglePlay, now time.Time) (AlertGooglePlay, bool) {
+	end := dateOf(now.AddDate(0, 0, 1))
+	windowStart := dateOf(now.AddDate(0, 0, 1-rule.WindowDays))
+	baselineStart := dateOf(now.AddDate(0, 0, 1-rule.WindowDays-rule.BaselineDays))
+	alert := AlertGooglePlay{PackageName: rule.PackageName, Type: rule.Type, TriggeredAt: now.Unix()}
+
+	switch rule.Type {
+	case alertTypeReviewVolume:
+		current := MongoCountGooglePlayReviewsOfClassBetween(mongoClient, rule.PackageName, rule.Class, windowStart, end)
+		previous := MongoCountGooglePlayReviewsOfClassBetween(mongoClient, rule.PackageName, rule.Class, baselineStart, windowStart)
+		alert.Value = float64(current) / float64(rule.WindowDays)
+		alert.Baseline = float64(previous) / float64(rule.BaselineDays)
+		alert.Message = fmt.Sprintf("%.1f %s reviews per day, baseline is %.1f", alert.Value, rule.Class, alert.Baseline)
+		return alert, current >= rule.MinCount && alert.Value > alert.Baseline*rule.Threshold
+
+	case alertTypeRatingDrop:
+		current, currentCount := MongoGetGooglePlayReviewRatingBetween(mongoClient, rule.PackageName, windowStart, end)
+		previous, previousCount := MongoGetGooglePlayReviewRatingBetween(mongoClient, rule.PackageName, baselineStart, windowStart)
+		alert.Value, alert.Baseline = current, previous
+		alert.Message = fmt.Sprintf("average review rating dropped to %.2f, baseline is %.2f", current, previous)
+		return alert, currentCount >= rule.MinCount && previousCount > 0 && previous-current >= rule.Threshold
+
+	case alertTypePageRatingDrop:
+		pages := MongoGetAppPagesGooglePlayBetween(mongoClient, rule.PackageName, baselineStart, end)
+		if len(pages) < 2 || pages[len(pages)-1].DateCrawled < windowStart {
+			return alert, false
+		}
+		var sum float64
+		var count int
+		for _, page := range pages {
+			if page.DateCrawled < windowStart {
+				sum += page.Rating
+				count++
+			}
+		}
+		if count == 0 {
+			return alert, false
+		}
+		alert.Value = pages[len(pages)-1].Rating
+		alert.Baseline = sum / float64(count)
+		alert.Message = fmt.Sprintf("app page rating dropped to %.2f, baseline is %.2f", alert.Value, alert.Baseline)
+		return alert, alert.Baseline-alert.Value >= rule.Threshold
+	}
+
+	return alert, false
+}
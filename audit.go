@@ -0,0 +1,146 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// actions of the audit entries, named after the written documents
+const (
+	auditStoreAppPage       = "app_page.store"
+	auditStoreAppReviews    = "app_reviews.store"
+	auditImportAppPages     = "app_pages.import"
+	auditImportAppReviews   = "app_reviews.import"
+	auditStoreObservable    = "observable.store"
+	auditDeleteObservable   = "observable.delete"
+	auditStoreAlertRule     = "alert_rule.store"
+	auditDeleteAlertRule    = "alert_rule.delete"
+	auditEvaluateAlerts     = "alerts.evaluate"
+	auditStoreSubscription  = "subscription.store"
+	auditDeleteSubscription = "subscription.delete"
+	auditEraseAuthor        = "author.erase"
+
+	// maxAuditKeys are recorded per entry, the other keys are only counted
+	maxAuditKeys = 1000
+)
+
+// auditFilter selects a page of the audit entries
+type auditFilter struct {
+	action string
+	client string
+	key    string
+	from   int64
+	to     int64
+	offset int
+	limit  int
+}
+
+// auditKeys collects the distinct keys of a write, only the first maxAuditKeys are kept and the others counted. The
+// methods of a nil collector do nothing, so that writes outside of requests need not collect keys.
+type auditKeys struct {
+	keys    []string
+	seen    map[string]bool
+	omitted int
+}
+
+func (k *auditKeys) add(key string) {
+	if k == nil || k.seen[key] {
+		return
+	}
+	if len(k.keys) >= maxAuditKeys {
+		k.omitted++
+		return
+	}
+	if k.seen == nil {
+		k.seen = map[string]bool{}
+	}
+	k.seen[key] = true
+	k.keys = append(k.keys, key)
+}
+
+// addAppReview adds the package name and the id of the review, so that the writes of an app can be found by its
+// package name
+func (k *auditKeys) addAppReview(review AppReviewGooglePlay) {
+	k.add(review.PackageName)
+	k.add(review.ReviewID)
+}
+
+// audit records a write of the request in the audit log of the tenant with the client, route and time of the
+// request. The write happened already, so a failure to record it is logged by the storage operation but does not fail
+// the request.
+func audit(r *http.Request, m *storageSession, keys *auditKeys, entry AuditEntryGooglePlay) {
+	entry.AuditID = randomToken(16)
+	entry.Route = r.Method + " " + routeTemplate(r)
+	entry.Client = clientKey(r)
+	entry.RequestID, _ = r.Context().Value(requestIDKey).(string)
+	entry.Keys = append([]string{}, keys.keys...)
+	entry.KeysOmitted = keys.omitted
+	entry.Timestamp = time.Now().Unix()
+
+	MongoInsertAuditEntryGooglePlay(m, entry)
+}
+
+// auditKey returns the collector of a write of a single document
+func auditKey(key string) *auditKeys {
+	keys := &auditKeys{}
+	keys.add(key)
+
+	return keys
+}
+
+// alertRuleKeys returns the keys of an alert rule, its package name and the package name with the type of the rule
+func alertRuleKeys(packageName, ruleType string) *auditKeys {
+	keys := auditKey(packageName)
+	keys.add(packageName + "/" + ruleType)
+
+	return keys
+}
+
+// auditAppPage records a stored app page, it is existing if it was stored before
+func auditAppPage(r *http.Request, m *storageSession, appPage AppPageGooglePlay, isNew, ok bool) {
+	counts := map[string]int{"inserted": 0, "existing": 0}
+	switch {
+	case isNew:
+		counts["inserted"] = 1
+	case ok:
+		counts["existing"] = 1
+	}
+	audit(r, m, auditKey(appPage.PackageName), AuditEntryGooglePlay{Action: auditStoreAppPage, Counts: counts, Failed: !ok})
+}
+
+// auditAppReviews records stored reviews, failed reviews are neither inserted nor updated
+func auditAppReviews(r *http.Request, m *storageSession, appReviews []AppReviewGooglePlay, inserted, updated int, ok bool) {
+	keys := &auditKeys{}
+	for _, review := range appReviews {
+		keys.addAppReview(review)
+	}
+	audit(r, m, keys, AuditEntryGooglePlay{
+		Action: auditStoreAppReviews,
+		Counts: map[string]int{"received": len(appReviews), "inserted": inserted, "updated": updated},
+		Failed: !ok,
+	})
+}
+
+// auditImport records an import with the counts of its summary
+func auditImport(r *http.Request, m *storageSession, action string, keys *auditKeys, summary ImportSummary, err error) {
+	audit(r, m, keys, AuditEntryGooglePlay{
+		Action: action,
+		Counts: map[string]int{
+			"read":     summary.Read,
+			"inserted": summary.Inserted,
+			"updated":  summary.Updated,
+			"existing": summary.Existing,
+			"rejected": summary.Rejected,
+		},
+		Failed: err != nil,
+	})
+}
+
+// deletedCount returns the counts of a delete, found is false if there was nothing to delete
+func deletedCount(found bool) map[string]int {
+	if found {
+		return map[string]int{"deleted": 1}
+	}
+
+	return map[string]int{"deleted": 0}
+}
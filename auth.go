@@ -0,0 +1,354 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// roleCrawler writes the crawled app pages and reviews
+	roleCrawler = "crawler"
+	// roleAnalyst reads and exports the stored data
+	roleAnalyst = "analyst"
+	// roleAdmin may call every route
+	roleAdmin = "admin"
+
+	apiKeyHeader = "X-API-Key"
+
+	// jwtClockSkew is tolerated when checking the expiry and not before times of a token
+	jwtClockSkew = 30 * time.Second
+)
+
+var roles = map[string]bool{roleCrawler: true, roleAnalyst: true, roleAdmin: true}
+
+// routeRoles lists the roles besides admin that may call a route. Routes missing here are reserved to admins, only
+// the probe paths can be called without authentication.
+var routeRoles = map[string][]string{
+	"POST /hitec/repository/app/store/app-page/google-play/":          {roleCrawler},
+	"POST /hitec/repository/app/store/app-review/google-play/":        {roleCrawler},
+	"POST /hitec/repository/app/non-existing/app-review/google-play/": {roleCrawler},
+
+	"GET /hitec/repository/app/observable/google-play":                                         {roleCrawler, roleAnalyst},
+	"GET /hitec/repository/app/google-play/package-name/{package_name}/class/{class}":          {roleAnalyst},
+	"GET /hitec/repository/app/google-play/package-name/{package_name}/class/{class}/clusters": {roleAnalyst},
+	"GET /hitec/repository/app/alert-rule/google-play/package-name/{package_name}":             {roleAnalyst},
+	"GET /hitec/repository/app/alert/google-play/package-name/{package_name}":                  {roleAnalyst},
+	"GET /hitec/repository/app/subscription/google-play/":                                      {roleAnalyst},
+	"GET /hitec/repository/app/subscription/google-play/{subscription_id}/deliveries":          {roleAnalyst},
+	"GET /hitec/repository/app/stream/app-review/google-play":                                  {roleAnalyst},
+	"GET /hitec/repository/app/export/app-review/google-play":                                  {roleAnalyst},
+	"GET /hitec/repository/app/export/app-page/google-play":                                    {roleAnalyst},
+	"POST /graphql":       {roleAnalyst},
+	"GET /graphql":        {roleAnalyst},
+	"GET /graphql/schema": {roleAnalyst},
+
+	// monitoring systems scrape the metrics with the credentials of an analyst
+	"GET /metrics": {roleAnalyst},
+}
+
+// adminOnly marks the routes of the route tables that only admins may call
+var adminOnly []string
+
+// rolesOf returns the roles besides admin that may call a route, the roles of the v2 routes and the gRPC methods are
+// declared with the routes
+func rolesOf(route string) []string {
+	if roles, ok := v2RouteRoles[route]; ok {
+		return roles
+	}
+	if roles, ok := grpcMethodRoles[route]; ok {
+		return roles
+	}
+
+	return routeRoles[route]
+}
+
+// principal is an authenticated client of a tenant
+type principal struct {
+	name   string
+	roles  []string
+	tenant string
+}
+
+func (p principal) hasRole(role string) bool {
+	for _, r := range p.roles {
+		if r == role {
+			return true
+		}
+	}
+
+	return false
+}
+
+// mayCall returns true if the principal is allowed to call the route
+func (p principal) mayCall(route string) bool {
+	if p.hasRole(roleAdmin) {
+		return true
+	}
+	for _, role := range rolesOf(route) {
+		if p.hasRole(role) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func principalFrom(ctx context.Context) (principal, bool) {
+	p, ok := ctx.Value(principalKey).(principal)
+	return p, ok
+}
+
+// authenticator checks the API keys and JWT bearer tokens of the clients
+type authenticator struct {
+	apiKeys      []apiKey
+	jwtSecret    []byte
+	jwtPublicKey *rsa.PublicKey
+	jwtIssuer    string
+	jwtAudience  string
+}
+
+type apiKey struct {
+	name   string
+	role   string
+	tenant string
+	hash   []byte
+}
+
+// authentication is nil if authentication is disabled, the API is open then
+var authentication *authenticator
+
+// newAuthenticator returns nil if authentication is disabled. Without API keys and JWT settings the authenticator
+// rejects every client, so that a missing configuration does not open the API.
+func newAuthenticator(c AuthConfig) (*authenticator, error) {
+	if c.Disabled {
+		return nil, nil
+	}
+
+	a := &authenticator{jwtIssuer: c.JWT.Issuer, jwtAudience: c.JWT.Audience}
+	for _, key := range c.APIKeys {
+		hash, err := hex.DecodeString(key.KeySHA256)
+		if err != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("key_sha256 of API key %q must be a hex encoded SHA-256 hash", key.Name)
+		}
+		a.apiKeys = append(a.apiKeys, apiKey{name: key.Name, role: key.Role, tenant: key.Tenant, hash: hash})
+	}
+	if c.JWT.Secret != "" {
+		a.jwtSecret = []byte(c.JWT.Secret)
+	}
+	if c.JWT.PublicKeyFile != "" {
+		publicKey, err := loadRSAPublicKey(c.JWT.PublicKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		a.jwtPublicKey = publicKey
+	}
+
+	return a, nil
+}
+
+func loadRSAPublicKey(file string) (*rsa.PublicKey, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s is not PEM encoded", file)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+	publicKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an RSA public key", file)
+	}
+
+	return publicKey, nil
+}
+
+var errNoCredentials = errors.New("no API key or bearer token")
+
+// acceptsNoClient returns true if neither API keys nor JWT verification are configured
+func (a *authenticator) acceptsNoClient() bool {
+	return len(a.apiKeys) == 0 && a.jwtSecret == nil && a.jwtPublicKey == nil
+}
+
+// authenticate returns the client identified by the API key or bearer token of the request
+func (a *authenticator) authenticate(r *http.Request) (principal, error) {
+	if key := r.Header.Get(apiKeyHeader); key != "" {
+		return a.authenticateAPIKey(key)
+	}
+	if authorization := r.Header.Get("Authorization"); authorization != "" {
+		scheme, token, _ := strings.Cut(authorization, " ")
+		if !strings.EqualFold(scheme, "Bearer") {
+			return principal{}, fmt.Errorf("unsupported authorization scheme %q", scheme)
+		}
+		return a.authenticateJWT(strings.TrimSpace(token), time.Now())
+	}
+
+	return principal{}, errNoCredentials
+}
+
+func (a *authenticator) authenticateAPIKey(key string) (principal, error) {
+	hash := sha256.Sum256([]byte(key))
+	for _, k := range a.apiKeys {
+		if subtle.ConstantTimeCompare(hash[:], k.hash) == 1 {
+			return principal{name: k.name, roles: []string{k.role}, tenant: k.tenant}, nil
+		}
+	}
+
+	return principal{}, errors.New("unknown API key")
+}
+
+type jwtHeader struct {
+	Algorithm string `json:"alg"`
+}
+
+type jwtClaims struct {
+	Subject   string          `json:"sub"`
+	Issuer    string          `json:"iss"`
+	Audience  json.RawMessage `json:"aud"`
+	ExpiresAt *float64        `json:"exp"`
+	NotBefore *float64        `json:"nbf"`
+	Role      string          `json:"role"`
+	Roles     []string        `json:"roles"`
+	Tenant    string          `json:"tenant"`
+}
+
+// authenticateJWT verifies a compact JWS signed with HS256 or RS256 and returns its subject with its roles and tenant
+func (a *authenticator) authenticateJWT(token string, now time.Time) (principal, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return principal{}, errors.New("malformed bearer token")
+	}
+	var header jwtHeader
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return principal{}, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return principal{}, errors.New("malformed token signature")
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+	switch {
+	case header.Algorithm == "HS256" && a.jwtSecret != nil:
+		mac := hmac.New(sha256.New, a.jwtSecret)
+		mac.Write(signed)
+		if !hmac.Equal(signature, mac.Sum(nil)) {
+			return principal{}, errors.New("invalid token signature")
+		}
+	case header.Algorithm == "RS256" && a.jwtPublicKey != nil:
+		digest := sha256.Sum256(signed)
+		if err = rsa.VerifyPKCS1v15(a.jwtPublicKey, crypto.SHA256, digest[:], signature); err != nil {
+			return principal{}, errors.New("invalid token signature")
+		}
+	default:
+		return principal{}, fmt.Errorf("unsupported token algorithm %q", header.Algorithm)
+	}
+
+	var claims jwtClaims
+	if err = decodeJWTPart(parts[1], &claims); err != nil {
+		return principal{}, err
+	}
+	if claims.ExpiresAt == nil || now.After(time.Unix(int64(*claims.ExpiresAt), 0).Add(jwtClockSkew)) {
+		return principal{}, errors.New("token expired")
+	}
+	if claims.NotBefore != nil && now.Add(jwtClockSkew).Before(time.Unix(int64(*claims.NotBefore), 0)) {
+		return principal{}, errors.New("token not valid yet")
+	}
+	if a.jwtIssuer != "" && claims.Issuer != a.jwtIssuer {
+		return principal{}, fmt.Errorf("unexpected token issuer %q", claims.Issuer)
+	}
+	if a.jwtAudience != "" && !claims.hasAudience(a.jwtAudience) {
+		return principal{}, errors.New("token is meant for another audience")
+	}
+	if claims.Subject == "" {
+		return principal{}, errors.New("token has no subject")
+	}
+	if !isKnownTenant(claims.Tenant) {
+		return principal{}, fmt.Errorf("unknown tenant %q", claims.Tenant)
+	}
+
+	p := principal{name: claims.Subject, tenant: claims.Tenant}
+	for _, role := range append(claims.Roles, claims.Role) {
+		if roles[role] {
+			p.roles = append(p.roles, role)
+		}
+	}
+
+	return p, nil
+}
+
+// hasAudience checks the aud claim, which is either a string or an array of strings
+func (c jwtClaims) hasAudience(audience string) bool {
+	var one string
+	if json.Unmarshal(c.Audience, &one) == nil {
+		return one == audience
+	}
+	var many []string
+	if json.Unmarshal(c.Audience, &many) == nil {
+		for _, a := range many {
+			if a == audience {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return errors.New("malformed bearer token")
+	}
+	if err = json.Unmarshal(data, v); err != nil {
+		return errors.New("malformed bearer token")
+	}
+
+	return nil
+}
+
+// authorize answers 401 Unauthorized to requests without valid credentials and 403 Forbidden if the role of the
+// client does not allow the route. The request is scoped to the tenant of the client. All requests pass as requests of
+// the default tenant if authentication is disabled.
+func authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if authentication == nil || probePaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		p, err := authentication.authenticate(r)
+		if err != nil {
+			loggerFrom(r.Context()).Warn("authentication failed", "method", r.Method, "path", r.URL.Path, "error", err)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+defaultServiceName+`"`)
+			writeError(w, r, http.StatusUnauthorized, errorCodeUnauthorized, "missing or invalid credentials", nil)
+			return
+		}
+		ctx := withTenant(context.WithValue(r.Context(), principalKey, p), p.tenant)
+		route := r.Method + " " + routeTemplate(r)
+		if !p.mayCall(route) {
+			loggerFrom(ctx).Warn("access denied", "route", route, "roles", p.roles)
+			writeError(w, r, http.StatusForbidden, errorCodeForbidden, "the role of the client does not allow "+route, nil)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
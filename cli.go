@@ -0,0 +1,355 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	mgo "gopkg.in/mgo.v2"
+)
+
+// command runs a subcommand of the binary with the arguments following its name
+type command struct {
+	usage string
+	run   func(mongoClient *mgo.Session, out io.Writer, args []string) error
+	// connects is set if the command connects to the database itself instead of getting a session
+	connects bool
+}
+
+const (
+	usageObserve = "observe [-tenant <tenant>] add <package_name> <interval> | remove <package_name> | list"
+	usageExport  = "export [-tenant <tenant>] [-format jsonl|csv] [-package <package_name>] [-from <date>] [-to <date>] review|page [<file>]"
+	usageImport  = "import [-tenant <tenant>] [-format jsonl|csv] review|page <file, - for stdin>"
+	usageStats   = "stats [-tenant <tenant>] [-package <package_name>]"
+	usagePurge   = "purge"
+	usageErase   = "erase [-tenant <tenant>] [-mode delete|redact] [-reason <reason>] <author>"
+)
+
+var commands = map[string]command{
+	"serve":          {"serve", runServeCommand, true},
+	"migrate":        {"migrate", runMigrateCommand, false},
+	"ensure-indexes": {"ensure-indexes", runEnsureIndexesCommand, false},
+	"observe":        {usageObserve, runObserveCommand, false},
+	"export":         {usageExport, runExportCommand, false},
+	"import":         {usageImport, runImportCommand, false},
+	"stats":          {usageStats, runStatsCommand, false},
+	"purge":          {usagePurge, runPurgeCommand, false},
+	"erase":          {usageErase, runEraseCommand, false},
+}
+
+// printUsage lists the subcommands of the binary
+func printUsage(out io.Writer) {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(out, "usage: app [-config <file>] [-listen <address>] [-mongo-uri <uri>] [-database <name>] <command> [arguments]")
+	fmt.Fprintln(out, "serve is the default command, the commands are:")
+	for _, name := range names {
+		fmt.Fprintln(out, "  "+commands[name].usage)
+	}
+}
+
+// runCommand runs the subcommand named by the first argument
+func runCommand(mongoClient *mgo.Session, out io.Writer, args []string) error {
+	if len(args) == 0 {
+		return errors.New("missing command")
+	}
+	c, ok := commands[args[0]]
+	if !ok {
+		return errors.New("unknown command " + args[0])
+	}
+
+	return c.run(mongoClient, out, args[1:])
+}
+
+// tenantFlag adds the -tenant flag selecting the tenant whose data a command works on
+func tenantFlag(flags *flag.FlagSet) *string {
+	return flags.String("tenant", "", "tenant whose data is used, the default tenant if empty")
+}
+
+// commandSession returns a copy of the session bound to the tenant, release closes it
+func commandSession(mongoClient *mgo.Session, tenant string) (m *storageSession, release func(), err error) {
+	if !isKnownTenant(tenant) {
+		return nil, nil, errors.New("unknown tenant " + tenant)
+	}
+	m, release = tenantSession(mongoClient, tenant)
+
+	return m, release, nil
+}
+
+func writeIndentedJSON(out io.Writer, v interface{}) error {
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(v)
+}
+
+// runEnsureIndexesCommand creates the collection indexes of all tenants
+func runEnsureIndexesCommand(mongoClient *mgo.Session, out io.Writer, args []string) error {
+	forEachTenant(mongoClient, MongoCreateCollectionIndexes)
+	fmt.Fprintln(out, "indexes are up to date")
+
+	return nil
+}
+
+// runMigrateCommand ensures the indexes, adds labels, fingerprints and duplicate flags to reviews stored by older
+// versions and dates the app reviews and app pages stored without a stored_at date to now, for all tenants
+func runMigrateCommand(mongoClient *mgo.Session, out io.Writer, args []string) error {
+	migrated, backfilled := 0, 0
+	now := time.Now()
+	var err error
+	forEachTenant(mongoClient, func(m *storageSession) {
+		MongoCreateCollectionIndexes(m)
+		if err != nil {
+			return
+		}
+		var tenantMigrated, tenantBackfilled int
+		tenantMigrated, err = MongoMigrateAppReviewGooglePlay(m)
+		migrated += tenantMigrated
+		if err != nil {
+			return
+		}
+		tenantBackfilled, err = MongoBackfillStoredAt(m, now)
+		backfilled += tenantBackfilled
+	})
+	fmt.Fprintf(out, "migrated %d app reviews\n", migrated)
+	fmt.Fprintf(out, "dated %d app reviews and app pages without stored_at\n", backfilled)
+
+	return err
+}
+
+// runObserveCommand manages the apps observed by the crawlers
+func runObserveCommand(mongoClient *mgo.Session, out io.Writer, args []string) error {
+	flags := flag.NewFlagSet("observe", flag.ContinueOnError)
+	tenant := tenantFlag(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	args = flags.Args()
+	usage := errors.New("usage: " + usageObserve)
+	if len(args) == 0 {
+		return usage
+	}
+	m, release, err := commandSession(mongoClient, *tenant)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	switch {
+	case args[0] == "add" && len(args) == 3:
+		observable := ObservableGooglePlay{PackageName: args[1], Interval: args[2]}
+		if errs := validateObservable(observable); len(errs) > 0 {
+			return errs
+		}
+		if !MongoInsertObservableGooglePlay(m, observable) {
+			return errors.New("could not observe " + args[1])
+		}
+		fmt.Fprintf(out, "observing %s %s\n", args[1], args[2])
+
+	case args[0] == "remove" && len(args) == 2:
+		found, ok := MongoDeleteObservableGooglePlay(m, args[1])
+		if !ok {
+			return errors.New("could not remove " + args[1])
+		}
+		if !found {
+			return errors.New(args[1] + " is not observed")
+		}
+		fmt.Fprintf(out, "removed %s\n", args[1])
+
+	case args[0] == "list" && len(args) == 1:
+		for _, observable := range MongoGetAllObservableGooglePlay(m) {
+			fmt.Fprintf(out, "%s\t%s\n", observable.PackageName, observable.Interval)
+		}
+
+	default:
+		return usage
+	}
+
+	return nil
+}
+
+// runExportCommand exports app reviews or app pages to a file or stdout
+func runExportCommand(mongoClient *mgo.Session, out io.Writer, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	tenant := tenantFlag(flags)
+	format := flags.String("format", exportFormatJSONLines, "format of the file, jsonl or csv")
+	packageName := flags.String("package", "", "only export documents of this package name")
+	from := flags.Int64("from", 0, "only export documents of or after this date, e.g. 20191101")
+	to := flags.Int64("to", 0, "only export documents of or before this date, e.g. 20191130")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() < 1 || flags.NArg() > 2 {
+		return errors.New("usage: " + usageExport)
+	}
+	if !isValidExportFormat(*format) {
+		return errors.New("unknown format " + *format)
+	}
+
+	var export func(*storageSession, io.Writer, string, exportFilter) (int, error)
+	switch flags.Arg(0) {
+	case "review":
+		export = exportAppReviews
+	case "page":
+		export = exportAppPages
+	default:
+		return errors.New("unknown kind " + flags.Arg(0) + ", expected review or page")
+	}
+
+	if file := flags.Arg(1); file != "" && file != "-" {
+		f, err := os.Create(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+
+	m, release, err := commandSession(mongoClient, *tenant)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	filter := exportFilter{packageName: *packageName, from: *from, to: *to}
+	_, err = export(m, out, *format, filter)
+
+	return err
+}
+
+// runImportCommand imports a JSON Lines or CSV file of app reviews or app pages and prints the summary
+func runImportCommand(mongoClient *mgo.Session, out io.Writer, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	tenant := tenantFlag(flags)
+	format := flags.String("format", exportFormatJSONLines, "format of the file, jsonl or csv")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		return errors.New("usage: " + usageImport)
+	}
+	if !isValidExportFormat(*format) {
+		return errors.New("unknown format " + *format)
+	}
+
+	var importFile func(*storageSession, io.Reader, string, *auditKeys) (ImportSummary, error)
+	switch flags.Arg(0) {
+	case "review":
+		importFile = importAppReviews
+	case "page":
+		importFile = importAppPages
+	default:
+		return errors.New("unknown kind " + flags.Arg(0) + ", expected review or page")
+	}
+
+	in := io.Reader(os.Stdin)
+	if flags.Arg(1) != "-" {
+		file, err := os.Open(flags.Arg(1))
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		in = file
+	}
+
+	m, release, err := commandSession(mongoClient, *tenant)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	MongoCreateCollectionIndexes(m)
+	stop := make(chan struct{})
+	var deliveries sync.WaitGroup
+	startWebhookWorkers(mongoClient, &deliveries, stop)
+	defer deliveries.Wait()
+	defer close(stop)
+	summary, err := importFile(m, in, *format, nil)
+	if encodeErr := writeIndentedJSON(out, summary); encodeErr != nil && err == nil {
+		err = encodeErr
+	}
+
+	return err
+}
+
+// runStatsCommand prints the number of stored documents
+func runStatsCommand(mongoClient *mgo.Session, out io.Writer, args []string) error {
+	flags := flag.NewFlagSet("stats", flag.ContinueOnError)
+	tenant := tenantFlag(flags)
+	packageName := flags.String("package", "", "only count documents of this package name")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 0 {
+		return errors.New("usage: " + usageStats)
+	}
+
+	m, release, err := commandSession(mongoClient, *tenant)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	statistics, err := MongoGetStatisticsGooglePlay(m, *packageName)
+	if err != nil {
+		return err
+	}
+
+	return writeIndentedJSON(out, statistics)
+}
+
+// runPurgeCommand removes the documents older than the max age of their collection for all tenants, e.g. from a cron
+// job instead of the purge job of the server
+func runPurgeCommand(mongoClient *mgo.Session, out io.Writer, args []string) error {
+	if len(args) != 0 {
+		return errors.New("usage: " + usagePurge)
+	}
+	purged := 0
+	forEachTenant(mongoClient, func(m *storageSession) {
+		purged += purgeExpiredDocuments(m, time.Now())
+	})
+	fmt.Fprintf(out, "purged %d documents\n", purged)
+
+	return nil
+}
+
+// runEraseCommand deletes the reviews of an author or removes the author from them and prints the erasure record
+func runEraseCommand(mongoClient *mgo.Session, out io.Writer, args []string) error {
+	flags := flag.NewFlagSet("erase", flag.ContinueOnError)
+	tenant := tenantFlag(flags)
+	mode := flags.String("mode", erasureDelete, "delete the reviews or redact their author")
+	reason := flags.String("reason", "", "reason of the erasure, e.g. the ticket of the request")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("usage: " + usageErase)
+	}
+	request := ErasureRequest{Author: flags.Arg(0), Mode: *mode, Reason: *reason}
+	if errs := validateErasureRequest(request); len(errs) > 0 {
+		return errs
+	}
+
+	m, release, err := commandSession(mongoClient, *tenant)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	erasure, ok := eraseAuthor(m, request, "cli", time.Now())
+	if !ok {
+		return errors.New("could not erase the author")
+	}
+
+	return writeIndentedJSON(out, erasure)
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+const (
+	maxClusters              = 50
+	clusterIterations        = 25
+	clusterKeyTerms          = 5
+	clusterRepresentatives   = 3
+	minClusterTermLength     = 3
+	defaultReviewsPerCluster = 8
+)
+
+var clusterStopWords = map[string]bool{
+	"about": true, "after": true, "again": true, "all": true, "also": true, "and": true, "any": true,
+	"app": true, "are": true, "because": true, "been": true, "before": true, "but": true, "can": true,
+	"cant": true, "could": true, "did": true, "does": true, "dont": true, "each": true, "even": true,
+	"every": true, "for": true, "from": true, "get": true, "had": true, "has": true, "have": true,
+	"her": true, "him": true, "his": true, "how": true, "into": true, "its": true, "just": true,
+	"like": true, "more": true, "most": true, "much": true, "not": true, "now": true, "off": true,
+	"once": true, "only": true, "other": true, "our": true, "out": true, "over": true, "please": true,
+	"really": true, "same": true, "she": true, "should": true, "some": true, "still": true, "such": true,
+	"than": true, "that": true, "the": true, "their": true, "them": true, "then": true, "there": true,
+	"these": true, "they": true, "this": true, "those": true, "too": true, "use": true, "very": true,
+	"was": true, "way": true, "were": true, "what": true, "when": true, "where": true, "which": true,
+	"while": true, "who": true, "why": true, "will": true, "with": true, "would": true, "you": true,
+	"your": true,
+}
+
+// sparseVector maps term indexes to weights
+type sparseVector map[int]float64
+
+// defaultClusterCount returns the number of clusters used when the client does not choose one
+func defaultClusterCount(reviewCount int) int {
+	k := int(math.Ceil(float64(reviewCount) / defaultReviewsPerCluster))
+	if k < 1 {
+		k = 1
+	}
+	if k > 10 {
+		k = 10
+	}
+
+	return k
+}
+
+// clusterReviews groups the reviews into at most k clusters by the tf-idf similarity of their texts
+func clusterReviews(reviews []AppReviewGooglePlay, k int) []ReviewCluster {
+	if len(reviews) == 0 {
+		return []ReviewCluster{}
+	}
+	if k > len(reviews) {
+		k = len(reviews)
+	}
+
+	vectors, vocabulary := tfidfVectors(reviews)
+	centroids := initialCentroids(vectors, k, len(vocabulary))
+	assignments := make([]int, len(vectors))
+	for iteration := 0; iteration < clusterIterations; iteration++ {
+		changed := false
+		for i, vector := range vectors {
+			best := nearestCentroid(vector, centroids)
+			if iteration == 0 || best != assignments[i] {
+				changed = true
+			}
+			assignments[i] = best
+		}
+		if !changed {
+			break
+		}
+		centroids = updateCentroids(vectors, assignments, centroids)
+	}
+
+	return buildClusters(reviews, vectors, vocabulary, assignments, centroids)
+}
+
+// tfidfVectors returns the normalized tf-idf vector of every review and the vocabulary of all reviews
+func tfidfVectors(reviews []AppReviewGooglePlay) ([]sparseVector, []string) {
+	termIndex := map[string]int{}
+	var vocabulary []string
+	documentFrequency := map[int]int{}
+	termFrequencies := make([]map[int]int, len(reviews))
+
+	for i, review := range reviews {
+		termFrequencies[i] = map[int]int{}
+		for _, word := range normalizeReviewText(review) {
+			if len(word) < minClusterTermLength || clusterStopWords[word] {
+				continue
+			}
+			index, ok := termIndex[word]
+			if !ok {
+				index = len(vocabulary)
+				termIndex[word] = index
+				vocabulary = append(vocabulary, word)
+			}
+			if termFrequencies[i][index] == 0 {
+				documentFrequency[index]++
+			}
+			termFrequencies[i][index]++
+		}
+	}
+
+	vectors := make([]sparseVector, len(reviews))
+	for i, frequencies := range termFrequencies {
+		vector := sparseVector{}
+		for index, frequency := range frequencies {
+			idf := math.Log(float64(len(reviews)+1)/float64(documentFrequency[index]+1)) + 1
+			vector[index] = float64(frequency) * idf
+		}
+		vectors[i] = normalizeSparse(vector)
+	}
+
+	return vectors, vocabulary
+}
+
+func normalizeSparse(vector sparseVector) sparseVector {
+	var norm float64
+	for _, weight := range vector {
+		norm += weight * weight
+	}
+	if norm == 0 {
+		return vector
+	}
+	norm = math.Sqrt(norm)
+	for index := range vector {
+		vector[index] /= norm
+	}
+
+	return vector
+}
+
+func cosine(vector sparseVector, centroid []float64) float64 {
+	var similarity float64
+	for index, weight := range vector {
+		similarity += weight * centroid[index]
+	}
+
+	return similarity
+}
+
+// initialCentroids picks k reviews that are far apart from each other, starting with the first review
+func initialCentroids(vectors []sparseVector, k, dimensions int) [][]float64 {
+	centroids := [][]float64{denseVector(vectors[0], dimensions)}
+	closest := make([]float64, len(vectors))
+	for i := range closest {
+		closest[i] = math.Inf(-1)
+	}
+
+	for len(centroids) < k {
+		last := centroids[len(centroids)-1]
+		next, lowest := -1, math.Inf(1)
+		for i, vector := range vectors {
+			if similarity := cosine(vector, last); similarity > closest[i] {
+				closest[i] = similarity
+			}
+			if closest[i] < lowest {
+				next, lowest = i, closest[i]
+			}
+		}
+		centroids = append(centroids, denseVector(vectors[next], dimensions))
+	}
+
+	return centroids
+}
+
+func denseVector(vector sparseVector, dimensions int) []float64 {
+	dense := make([]float64, dimensions)
+	for index, weight := range vector {
+		dense[index] = weight
+	}
+
+	return dense
+}
+
+func nearestCentroid(vector sparseVector, centroids [][]float64) int {
+	best, bestSimilarity := 0, math.Inf(-1)
+	for c, centroid := range centroids {
+		if similarity := cosine(vector, centroid); similarity > bestSimilarity {
+			best, bestSimilarity = c, similarity
+		}
+	}
+
+	return best
+}
+
+// updateCentroids moves every centroid to the normalized mean of its reviews, empty clusters keep their centroid
+func updateCentroids(vectors []sparseVector, assignments []int, previous [][]float64) [][]float64 {
+	centroids := make([][]float64, len(previous))
+	for c := range centroids {
+		centroids[c] = make([]float64, len(previous[c]))
+	}
+	sizes := make([]int, len(previous))
+	for i, vector := range vectors {
+		sizes[assignments[i]]++
+		for index, weight := range vector {
+			centroids[assignments[i]][index] += weight
+		}
+	}
+
+	for c, centroid := range centroids {
+		if sizes[c] == 0 {
+			centroids[c] = previous[c]
+			continue
+		}
+		var norm float64
+		for _, weight := range centroid {
+			norm += weight * weight
+		}
+		if norm == 0 {
+			continue
+		}
+		norm = math.Sqrt(norm)
+		for index := range centroid {
+			centroid[index] /= norm
+		}
+	}
+
+	return centroids
+}
+
+// buildClusters collects the key terms and the reviews closest to the centroid of every non-empty cluster
+func buildClusters(reviews []AppReviewGooglePlay, vectors []sparseVector, vocabulary []string, assignments []int, centroids [][]float64) []ReviewCluster {
+	members := make([][]int, len(centroids))
+	for i, c := range assignments {
+		members[c] = append(members[c], i)
+	}
+
+	clusters := []ReviewCluster{}
+	for c, indexes := range members {
+		if len(indexes) == 0 {
+			continue
+		}
+		centroid := centroids[c]
+		sort.SliceStable(indexes, func(a, b int) bool {
+			return cosine(vectors[indexes[a]], centroid) > cosine(vectors[indexes[b]], centroid)
+		})
+
+		cluster := ReviewCluster{Size: len(indexes), KeyTerms: keyTerms(centroid, vocabulary)}
+		for rank, i := range indexes {
+			if rank < clusterRepresentatives {
+				cluster.RepresentativeReviews = append(cluster.RepresentativeReviews, reviews[i])
+			}
+			cluster.ReviewIDs = append(cluster.ReviewIDs, reviews[i].ReviewID)
+		}
+		clusters = append(clusters, cluster)
+	}
+
+	sort.SliceStable(clusters, func(a, b int) bool {
+		return clusters[a].Size > clusters[b].Size
+	})
+	for i := range clusters {
+		clusters[i].ClusterID = i
+	}
+
+	return clusters
+}
+
+func keyTerms(centroid []float64, vocabulary []string) []string {
+	indexes := make([]int, 0, len(centroid))
+	for index, weight := range centroid {
+		if weight > 0 {
+			indexes = append(indexes, index)
+		}
+	}
+	sort.SliceStable(indexes, func(a, b int) bool {
+		return centroid[indexes[a]] > centroid[indexes[b]]
+	})
+	if len(indexes) > clusterKeyTerms {
+		indexes = indexes[:clusterKeyTerms]
+	}
+
+	terms := make([]string, len(indexes))
+	for i, index := range indexes {
+		terms[i] = vocabulary[index]
+	}
+
+	return terms
+}
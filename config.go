@@ -0,0 +1,606 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	mgo "gopkg.in/mgo.v2"
+)
+
+// Config configures the service. Settings are read from the defaults, a JSON config file, the environment and the
+// command line flags, each overriding the former.
+type Config struct {
+	ListenAddress           string           `json:"listen_address"`
+	Mongo                   MongoConfig      `json:"mongo"`
+	Collections             CollectionConfig `json:"collections"`
+	AlertEvaluationInterval Duration         `json:"alert_evaluation_interval"`
+	OutboxPublisher         string           `json:"outbox_publisher"`
+	OutboxPollInterval      Duration         `json:"outbox_poll_interval"`
+	ShutdownTimeout         Duration         `json:"shutdown_timeout"`
+	LogLevel                string           `json:"log_level"`
+	LogFormat               string           `json:"log_format"`
+	Tracing                 TracingConfig    `json:"tracing"`
+	Auth                    AuthConfig       `json:"auth"`
+	Tenants                 []string         `json:"tenants"`
+	Limits                  LimitsConfig     `json:"limits"`
+	Retention               RetentionConfig  `json:"retention"`
+	Webhooks                WebhookConfig    `json:"webhooks"`
+}
+
+// WebhookConfig restricts the targets of the subscription and alert webhooks. Loopback, private, link-local and
+// other internal addresses are refused unless the host is allowed by name, by address or by a network like
+// 10.0.0.0/8.
+type WebhookConfig struct {
+	AllowedHosts []string `json:"allowed_hosts"`
+}
+
+// RetentionConfig configures how long the documents of the collections are kept and how the authors of reviews are
+// stored. Collections without a max age keep their documents forever.
+type RetentionConfig struct {
+	AppReviews        RetentionPolicy `json:"app_reviews"`
+	AppPages          RetentionPolicy `json:"app_pages"`
+	Alerts            RetentionPolicy `json:"alerts"`
+	WebhookDeliveries RetentionPolicy `json:"webhook_deliveries"`
+	Outbox            RetentionPolicy `json:"outbox"`
+	Audit             RetentionPolicy `json:"audit"`
+	PurgeInterval     Duration        `json:"purge_interval"`
+	// Authors is keep, pseudonymize or anonymize, pseudonyms are keyed by the author secret
+	Authors      string `json:"authors"`
+	AuthorSecret string `json:"author_secret"`
+}
+
+// RetentionPolicy removes the documents older than the max age, either by the purge job comparing the date of the
+// documents or by a TTL index of the database expiring them after they were stored
+type RetentionPolicy struct {
+	MaxAge Duration `json:"max_age"`
+	Mode   string   `json:"mode"`
+}
+
+// LimitsConfig protects the service from clients sending too many or too large requests. Rate limiting is disabled
+// if the rate is 0.
+type LimitsConfig struct {
+	MaxBodyBytes   int64   `json:"max_body_bytes"`
+	MaxArrayLength int     `json:"max_array_length"`
+	RateLimit      float64 `json:"rate_limit"`
+	RateLimitBurst int     `json:"rate_limit_burst"`
+}
+
+// AuthConfig configures the authentication of the clients. Without API keys and JWT verification every request is
+// rejected, unless authentication is disabled explicitly to open the API.
+type AuthConfig struct {
+	Disabled bool           `json:"disabled"`
+	APIKeys  []APIKeyConfig `json:"api_keys"`
+	JWT      JWTConfig      `json:"jwt"`
+}
+
+// APIKeyConfig grants a role in a tenant to the client sending the API key with the given SHA-256 hash, clients
+// without a tenant use the default tenant
+type APIKeyConfig struct {
+	Name      string `json:"name"`
+	Role      string `json:"role"`
+	Tenant    string `json:"tenant"`
+	KeySHA256 string `json:"key_sha256"`
+}
+
+// JWTConfig configures the verification of bearer tokens signed with HS256 by a shared secret or with RS256
+type JWTConfig struct {
+	Secret        string `json:"secret"`
+	PublicKeyFile string `json:"public_key_file"`
+	Issuer        string `json:"issuer"`
+	Audience      string `json:"audience"`
+}
+
+// TracingConfig configures the export of OpenTelemetry spans, tracing is disabled without an endpoint
+type TracingConfig struct {
+	OTLPEndpoint string `json:"otlp_endpoint"`
+	ServiceName  string `json:"service_name"`
+}
+
+// MongoConfig configures the connection to the database, either by a connection uri or by its addresses
+type MongoConfig struct {
+	URI           string    `json:"uri"`
+	Addresses     []string  `json:"addresses"`
+	ReplicaSet    string    `json:"replica_set"`
+	Username      string    `json:"username"`
+	Password      string    `json:"password"`
+	Database      string    `json:"database"`
+	DialTimeout   Duration  `json:"dial_timeout"`
+	SocketTimeout Duration  `json:"socket_timeout"`
+	PoolLimit     int       `json:"pool_limit"`
+	TLS           TLSConfig `json:"tls"`
+}
+
+// TLSConfig configures encrypted connections to the database
+type TLSConfig struct {
+	Enabled            bool   `json:"enabled"`
+	CAFile             string `json:"ca_file"`
+	CertFile           string `json:"cert_file"`
+	KeyFile            string `json:"key_file"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify"`
+}
+
+// CollectionConfig names the collections of the database
+type CollectionConfig struct {
+	AppReviews        string `json:"app_reviews"`
+	AppPages          string `json:"app_pages"`
+	Observables       string `json:"observables"`
+	AlertRules        string `json:"alert_rules"`
+	Alerts            string `json:"alerts"`
+	Subscriptions     string `json:"subscriptions"`
+	WebhookDeliveries string `json:"webhook_deliveries"`
+	Outbox            string `json:"outbox"`
+	OutboxOffsets     string `json:"outbox_offsets"`
+	Counters          string `json:"counters"`
+	Erasures          string `json:"erasures"`
+	Audit             string `json:"audit"`
+}
+
+// Duration is a time.Duration written as a string like 30s or 1h in the config file
+type Duration struct {
+	time.Duration
+}
+
+// UnmarshalJSON reads a duration string
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("%s is not a duration like \"30s\"", data)
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	d.Duration = duration
+
+	return nil
+}
+
+// MarshalJSON writes the duration as a string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// config is the configuration of the running binary
+var config = defaultConfig()
+
+func defaultConfig() Config {
+	return Config{
+		ListenAddress: ":9681",
+		Mongo: MongoConfig{
+			Addresses:   []string{"localhost"},
+			Database:    database,
+			DialTimeout: Duration{60 * time.Second},
+		},
+		Collections: CollectionConfig{
+			AppReviews:        collectionAppReviewsGooglePlay,
+			AppPages:          collectionAppPageGooglePlay,
+			Observables:       collectionObservableGooglePlay,
+			AlertRules:        collectionAlertRuleGooglePlay,
+			Alerts:            collectionAlertGooglePlay,
+			Subscriptions:     collectionSubscriptionGooglePlay,
+			WebhookDeliveries: collectionWebhookDeliveryGooglePlay,
+			Outbox:            collectionOutbox,
+			OutboxOffsets:     collectionOutboxOffset,
+			Counters:          collectionCounter,
+			Erasures:          collectionErasure,
+			Audit:             collectionAudit,
+		},
+		AlertEvaluationInterval: Duration{defaultAlertEvaluationInterval},
+		OutboxPollInterval:      Duration{defaultOutboxPollInterval},
+		ShutdownTimeout:         Duration{30 * time.Second},
+		LogLevel:                "info",
+		LogFormat:               logFormatJSON,
+		Tracing:                 TracingConfig{ServiceName: defaultServiceName},
+		Limits:                  LimitsConfig{MaxBodyBytes: defaultMaxBodyBytes, MaxArrayLength: defaultMaxArrayLength},
+		Retention:               RetentionConfig{PurgeInterval: Duration{defaultPurgeInterval}, Authors: authorsKeep},
+	}
+}
+
+// configEnv maps the environment variables to the settings they override
+var configEnv = []struct {
+	name string
+	set  func(c *Config, value string) error
+}{
+	{"LISTEN_ADDRESS", func(c *Config, v string) error { c.ListenAddress = v; return nil }},
+	{"MONGO_URI", func(c *Config, v string) error { c.Mongo.URI = v; return nil }},
+	{"MONGO_IP", func(c *Config, v string) error { c.Mongo.Addresses = strings.Split(v, ","); return nil }},
+	{"MONGO_REPLICA_SET", func(c *Config, v string) error { c.Mongo.ReplicaSet = v; return nil }},
+	{"MONGO_USERNAME", func(c *Config, v string) error { c.Mongo.Username = v; return nil }},
+	{"MONGO_PASSWORD", func(c *Config, v string) error { c.Mongo.Password = v; return nil }},
+	{"MONGO_DATABASE", func(c *Config, v string) error { c.Mongo.Database = v; return nil }},
+	{"MONGO_DIAL_TIMEOUT", func(c *Config, v string) error { return parseDurationSetting(&c.Mongo.DialTimeout, v) }},
+	{"MONGO_SOCKET_TIMEOUT", func(c *Config, v string) error { return parseDurationSetting(&c.Mongo.SocketTimeout, v) }},
+	{"MONGO_POOL_LIMIT", func(c *Config, v string) error { return parseIntSetting(&c.Mongo.PoolLimit, v) }},
+	{"MONGO_TLS", func(c *Config, v string) error { return parseBoolSetting(&c.Mongo.TLS.Enabled, v) }},
+	{"MONGO_TLS_CA_FILE", func(c *Config, v string) error { c.Mongo.TLS.CAFile = v; return nil }},
+	{"MONGO_TLS_CERT_FILE", func(c *Config, v string) error { c.Mongo.TLS.CertFile = v; return nil }},
+	{"MONGO_TLS_KEY_FILE", func(c *Config, v string) error { c.Mongo.TLS.KeyFile = v; return nil }},
+	{"MONGO_TLS_INSECURE_SKIP_VERIFY", func(c *Config, v string) error { return parseBoolSetting(&c.Mongo.TLS.InsecureSkipVerify, v) }},
+	{"ALERT_EVALUATION_INTERVAL", func(c *Config, v string) error { return parseDurationSetting(&c.AlertEvaluationInterval, v) }},
+	{"OUTBOX_PUBLISHER", func(c *Config, v string) error { c.OutboxPublisher = v; return nil }},
+	{"OUTBOX_POLL_INTERVAL", func(c *Config, v string) error { return parseDurationSetting(&c.OutboxPollInterval, v) }},
+	{"SHUTDOWN_TIMEOUT", func(c *Config, v string) error { return parseDurationSetting(&c.ShutdownTimeout, v) }},
+	{"LOG_LEVEL", func(c *Config, v string) error { c.LogLevel = v; return nil }},
+	{"LOG_FORMAT", func(c *Config, v string) error { c.LogFormat = v; return nil }},
+	{"OTEL_EXPORTER_OTLP_ENDPOINT", func(c *Config, v string) error { c.Tracing.OTLPEndpoint = v; return nil }},
+	{"OTEL_SERVICE_NAME", func(c *Config, v string) error { c.Tracing.ServiceName = v; return nil }},
+	{"AUTH_DISABLED", func(c *Config, v string) error { return parseBoolSetting(&c.Auth.Disabled, v) }},
+	{"API_KEYS", parseAPIKeysSetting},
+	{"TENANTS", func(c *Config, v string) error { c.Tenants = strings.Split(v, ","); return nil }},
+	{"MAX_BODY_BYTES", func(c *Config, v string) error { return parseInt64Setting(&c.Limits.MaxBodyBytes, v) }},
+	{"MAX_ARRAY_LENGTH", func(c *Config, v string) error { return parseIntSetting(&c.Limits.MaxArrayLength, v) }},
+	{"RATE_LIMIT", func(c *Config, v string) error { return parseFloatSetting(&c.Limits.RateLimit, v) }},
+	{"RATE_LIMIT_BURST", func(c *Config, v string) error { return parseIntSetting(&c.Limits.RateLimitBurst, v) }},
+	{"JWT_SECRET", func(c *Config, v string) error { c.Auth.JWT.Secret = v; return nil }},
+	{"JWT_PUBLIC_KEY_FILE", func(c *Config, v string) error { c.Auth.JWT.PublicKeyFile = v; return nil }},
+	{"JWT_ISSUER", func(c *Config, v string) error { c.Auth.JWT.Issuer = v; return nil }},
+	{"JWT_AUDIENCE", func(c *Config, v string) error { c.Auth.JWT.Audience = v; return nil }},
+	{"RETENTION_APP_REVIEWS", func(c *Config, v string) error { return parseDurationSetting(&c.Retention.AppReviews.MaxAge, v) }},
+	{"RETENTION_APP_PAGES", func(c *Config, v string) error { return parseDurationSetting(&c.Retention.AppPages.MaxAge, v) }},
+	{"RETENTION_AUDIT", func(c *Config, v string) error { return parseDurationSetting(&c.Retention.Audit.MaxAge, v) }},
+	{"RETENTION_PURGE_INTERVAL", func(c *Config, v string) error { return parseDurationSetting(&c.Retention.PurgeInterval, v) }},
+	{"RETENTION_AUTHORS", func(c *Config, v string) error { c.Retention.Authors = v; return nil }},
+	{"RETENTION_AUTHOR_SECRET", func(c *Config, v string) error { c.Retention.AuthorSecret = v; return nil }},
+	{"WEBHOOK_ALLOWED_HOSTS", func(c *Config, v string) error { c.Webhooks.AllowedHosts = strings.Split(v, ","); return nil }},
+}
+
+func parseDurationSetting(d *Duration, value string) error {
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("%q is not a duration like 30s", value)
+	}
+	d.Duration = duration
+
+	return nil
+}
+
+// parseAPIKeysSetting reads comma separated API keys of the form name:role:sha256 or name:role:sha256:tenant
+func parseAPIKeysSetting(c *Config, value string) error {
+	c.Auth.APIKeys = nil
+	for _, entry := range strings.Split(value, ",") {
+		fields := strings.Split(strings.TrimSpace(entry), ":")
+		if len(fields) != 3 && len(fields) != 4 {
+			return fmt.Errorf("%q is not of the form name:role:sha256[:tenant]", entry)
+		}
+		key := APIKeyConfig{Name: fields[0], Role: fields[1], KeySHA256: fields[2]}
+		if len(fields) == 4 {
+			key.Tenant = fields[3]
+		}
+		c.Auth.APIKeys = append(c.Auth.APIKeys, key)
+	}
+
+	return nil
+}
+
+func parseInt64Setting(i *int64, value string) error {
+	v, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%q is not an integer", value)
+	}
+	*i = v
+
+	return nil
+}
+
+func parseFloatSetting(f *float64, value string) error {
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return fmt.Errorf("%q is not a number", value)
+	}
+	*f = v
+
+	return nil
+}
+
+func parseIntSetting(i *int, value string) error {
+	v, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("%q is not an integer", value)
+	}
+	*i = v
+
+	return nil
+}
+
+func parseBoolSetting(b *bool, value string) error {
+	v, err := strconv.ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("%q is not a boolean", value)
+	}
+	*b = v
+
+	return nil
+}
+
+// loadConfig reads the configuration from the config file, the environment and the flags preceding the command
+// and returns the validated configuration and the remaining arguments
+func loadConfig(args []string, getenv func(string) string) (Config, []string, error) {
+	flags := flag.NewFlagSet("app", flag.ContinueOnError)
+	configFile := flags.String("config", getenv("CONFIG_FILE"), "JSON config file")
+	listenAddress := flags.String("listen", "", "address the http api listens on, e.g. :9681")
+	mongoURI := flags.String("mongo-uri", "", "mongodb:// connection uri, e.g. for a replica set")
+	databaseName := flags.String("database", "", "name of the database")
+	if err := flags.Parse(args); err != nil {
+		return Config{}, nil, err
+	}
+
+	c := defaultConfig()
+	if *configFile != "" {
+		data, err := ioutil.ReadFile(*configFile)
+		if err != nil {
+			return c, nil, err
+		}
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err = decoder.Decode(&c); err != nil {
+			return c, nil, fmt.Errorf("config file %s: %s", *configFile, err)
+		}
+	}
+
+	for _, env := range configEnv {
+		value := getenv(env.name)
+		if value == "" {
+			continue
+		}
+		if err := env.set(&c, value); err != nil {
+			return c, nil, fmt.Errorf("environment variable %s: %s", env.name, err)
+		}
+	}
+
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "listen":
+			c.ListenAddress = *listenAddress
+		case "mongo-uri":
+			c.Mongo.URI = *mongoURI
+		case "database":
+			c.Mongo.Database = *databaseName
+		}
+	})
+
+	return c, flags.Args(), c.validate()
+}
+
+// validate returns an error listing every invalid setting
+func (c Config) validate() error {
+	var problems []string
+	check := func(ok bool, format string, a ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, a...))
+		}
+	}
+
+	_, _, err := net.SplitHostPort(c.ListenAddress)
+	check(err == nil, "listen_address %q must have the form host:port or :port", c.ListenAddress)
+
+	if c.Mongo.URI != "" {
+		_, err = mgo.ParseURL(c.Mongo.URI)
+		check(err == nil, "mongo.uri is invalid: %v", err)
+		check(c.Mongo.ReplicaSet == "", "mongo.replica_set must be part of mongo.uri when mongo.uri is set")
+	} else {
+		check(len(c.Mongo.Addresses) > 0, "mongo.addresses or mongo.uri must be set")
+		for _, address := range c.Mongo.Addresses {
+			check(strings.TrimSpace(address) != "", "mongo.addresses must not contain empty addresses")
+		}
+	}
+	check(c.Mongo.Database != "", "mongo.database must not be empty")
+	check(c.Mongo.DialTimeout.Duration > 0, "mongo.dial_timeout must be positive")
+	check(c.Mongo.SocketTimeout.Duration >= 0, "mongo.socket_timeout must not be negative")
+	check(c.Mongo.PoolLimit >= 0, "mongo.pool_limit must not be negative, 0 uses the driver default")
+	check((c.Mongo.TLS.CertFile == "") == (c.Mongo.TLS.KeyFile == ""), "mongo.tls.cert_file and mongo.tls.key_file must be set together")
+	for _, file := range []struct{ setting, path string }{
+		{"ca_file", c.Mongo.TLS.CAFile},
+		{"cert_file", c.Mongo.TLS.CertFile},
+		{"key_file", c.Mongo.TLS.KeyFile},
+	} {
+		if file.path == "" {
+			continue
+		}
+		check(c.Mongo.TLS.Enabled, "mongo.tls.%s requires mongo.tls.enabled", file.setting)
+		_, err = os.Stat(file.path)
+		check(err == nil, "mongo.tls.%s: %v", file.setting, err)
+	}
+
+	names := map[string]string{}
+	for _, collection := range []struct{ setting, name string }{
+		{"app_reviews", c.Collections.AppReviews},
+		{"app_pages", c.Collections.AppPages},
+		{"observables", c.Collections.Observables},
+		{"alert_rules", c.Collections.AlertRules},
+		{"alerts", c.Collections.Alerts},
+		{"subscriptions", c.Collections.Subscriptions},
+		{"webhook_deliveries", c.Collections.WebhookDeliveries},
+		{"outbox", c.Collections.Outbox},
+		{"outbox_offsets", c.Collections.OutboxOffsets},
+		{"counters", c.Collections.Counters},
+		{"erasures", c.Collections.Erasures},
+		{"audit", c.Collections.Audit},
+	} {
+		check(collection.name != "", "collections.%s must not be empty", collection.setting)
+		if other, ok := names[collection.name]; ok && collection.name != "" {
+			check(false, "collections.%s and collections.%s must not both be %q", other, collection.setting, collection.name)
+		}
+		names[collection.name] = collection.setting
+	}
+
+	check(c.AlertEvaluationInterval.Duration > 0, "alert_evaluation_interval must be positive")
+	check(c.OutboxPollInterval.Duration > 0, "outbox_poll_interval must be positive")
+	check(c.ShutdownTimeout.Duration > 0, "shutdown_timeout must be positive")
+	if c.OutboxPublisher != "" {
+		u, err := url.Parse(c.OutboxPublisher)
+		check(err == nil && (u.Scheme == "file" || u.Scheme == "nats"), "outbox_publisher %q must be a file:// or nats:// uri", c.OutboxPublisher)
+	}
+	_, ok := logLevels[c.LogLevel]
+	check(ok, "log_level %q must be one of debug, info, warn or error", c.LogLevel)
+	check(c.LogFormat == logFormatJSON || c.LogFormat == logFormatText, "log_format %q must be json or text", c.LogFormat)
+	if c.Tracing.OTLPEndpoint != "" {
+		u, err := url.Parse(c.Tracing.OTLPEndpoint)
+		check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "tracing.otlp_endpoint %q must be an http:// or https:// url", c.Tracing.OTLPEndpoint)
+	}
+
+	check(c.Limits.MaxBodyBytes > 0, "limits.max_body_bytes must be positive")
+	check(c.Limits.MaxArrayLength > 0, "limits.max_array_length must be positive")
+	check(c.Limits.RateLimit >= 0, "limits.rate_limit must not be negative, 0 disables rate limiting")
+	check(c.Limits.RateLimit == 0 || c.Limits.RateLimitBurst >= 1, "limits.rate_limit_burst must be at least 1 when rate limiting is enabled")
+
+	for _, policy := range []struct {
+		setting string
+		policy  RetentionPolicy
+		ttl     bool
+	}{
+		{"app_reviews", c.Retention.AppReviews, true},
+		{"app_pages", c.Retention.AppPages, true},
+		{"alerts", c.Retention.Alerts, false},
+		{"webhook_deliveries", c.Retention.WebhookDeliveries, false},
+		{"outbox", c.Retention.Outbox, false},
+		{"audit", c.Retention.Audit, false},
+	} {
+		check(policy.policy.MaxAge.Duration >= 0, "retention.%s.max_age must not be negative, 0 keeps the documents forever", policy.setting)
+		switch mode := policy.policy.Mode; {
+		case mode == "" || mode == retentionPurge || (mode == retentionTTL && policy.ttl):
+		case policy.ttl:
+			check(false, "retention.%s.mode %q must be purge or ttl", policy.setting, mode)
+		default:
+			check(false, "retention.%s.mode %q must be purge, the documents have no stored_at date for a ttl index", policy.setting, mode)
+		}
+	}
+	check(c.Retention.PurgeInterval.Duration > 0, "retention.purge_interval must be positive")
+	check(c.Retention.Authors == authorsKeep || c.Retention.Authors == authorsPseudonymize || c.Retention.Authors == authorsAnonymize,
+		"retention.authors %q must be keep, pseudonymize or anonymize", c.Retention.Authors)
+	check(c.Retention.Authors != authorsPseudonymize || c.Retention.AuthorSecret != "", "retention.author_secret must be set to pseudonymize authors")
+	for _, host := range c.Webhooks.AllowedHosts {
+		if strings.Contains(host, "/") {
+			_, _, err = net.ParseCIDR(host)
+			check(err == nil, "webhooks.allowed_hosts: %q is not a network like 10.0.0.0/8", host)
+		} else {
+			check((host != "" && !strings.ContainsAny(host, " :")) || net.ParseIP(host) != nil, "webhooks.allowed_hosts: %q is not a host name or address", host)
+		}
+	}
+
+	tenants := map[string]bool{"": true}
+	for _, tenant := range c.Tenants {
+		check(isValidTenant(tenant), "tenants: %q must be at most %d lower case letters, digits, _ and -", tenant, maxTenantLength)
+		check(!tenants[tenant], "tenants: %q is listed twice", tenant)
+		tenants[tenant] = true
+	}
+	apiKeyNames := map[string]bool{}
+	for _, key := range c.Auth.APIKeys {
+		check(key.Name != "" && !apiKeyNames[key.Name], "auth.api_keys must have unique names, %q is not", key.Name)
+		check(roles[key.Role], "auth.api_keys %q: role %q must be crawler, analyst or admin", key.Name, key.Role)
+		check(tenants[key.Tenant], "auth.api_keys %q: tenant %q must be listed in tenants", key.Name, key.Tenant)
+		apiKeyNames[key.Name] = true
+	}
+	check(!c.Auth.Disabled || (len(c.Auth.APIKeys) == 0 && c.Auth.JWT.Secret == "" && c.Auth.JWT.PublicKeyFile == ""),
+		"auth.disabled must not be set together with auth.api_keys or auth.jwt")
+	_, err = newAuthenticator(c.Auth)
+	check(err == nil, "auth: %v", err)
+
+	if len(problems) > 0 {
+		return errors.New("invalid configuration:\n  " + strings.Join(problems, "\n  "))
+	}
+
+	return nil
+}
+
+// applyConfig makes the configured database and collection names the ones used by all queries
+func applyConfig(c Config) {
+	config = c
+	database = c.Mongo.Database
+	collectionAppReviewsGooglePlay = c.Collections.AppReviews
+	collectionAppPageGooglePlay = c.Collections.AppPages
+	collectionObservableGooglePlay = c.Collections.Observables
+	collectionAlertRuleGooglePlay = c.Collections.AlertRules
+	collectionAlertGooglePlay = c.Collections.Alerts
+	collectionSubscriptionGooglePlay = c.Collections.Subscriptions
+	collectionWebhookDeliveryGooglePlay = c.Collections.WebhookDeliveries
+	collectionOutbox = c.Collections.Outbox
+	collectionOutboxOffset = c.Collections.OutboxOffsets
+	collectionCounter = c.Collections.Counters
+	collectionErasure = c.Collections.Erasures
+	collectionAudit = c.Collections.Audit
+	authentication, _ = newAuthenticator(c.Auth)
+	rateLimit = nil
+	if c.Limits.RateLimit > 0 {
+		rateLimit = newRateLimiter(c.Limits.RateLimit, c.Limits.RateLimitBurst)
+	}
+}
+
+// mongoDialInfo returns the dial settings of the configured database
+func mongoDialInfo(c MongoConfig) (*mgo.DialInfo, error) {
+	info := &mgo.DialInfo{
+		Addrs:          c.Addresses,
+		ReplicaSetName: c.ReplicaSet,
+		Username:       c.Username,
+		Password:       c.Password,
+	}
+	if c.URI != "" {
+		var err error
+		info, err = mgo.ParseURL(c.URI)
+		if err != nil {
+			return nil, err
+		}
+		if c.Username != "" {
+			info.Username, info.Password = c.Username, c.Password
+		}
+		// the user of the uri is authenticated against its authSource, the database of the uri or admin
+		if info.Source == "" {
+			info.Source = info.Database
+		}
+		if info.Source == "" {
+			info.Source = "admin"
+		}
+	}
+	info.Database = c.Database
+	info.Timeout = c.DialTimeout.Duration
+	if c.PoolLimit > 0 {
+		info.PoolLimit = c.PoolLimit
+	}
+
+	if c.TLS.Enabled {
+		tlsConfig, err := mongoTLSConfig(c.TLS)
+		if err != nil {
+			return nil, err
+		}
+		info.DialServer = func(addr *mgo.ServerAddr) (net.Conn, error) {
+			dialer := &net.Dialer{Timeout: info.Timeout}
+			return tls.DialWithDialer(dialer, "tcp", addr.String(), tlsConfig)
+		}
+	}
+
+	return info, nil
+}
+
+func mongoTLSConfig(c TLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: c.InsecureSkipVerify}
+	if c.CAFile != "" {
+		ca, err := ioutil.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("mongo.tls.ca_file %s contains no PEM certificates", c.CAFile)
+		}
+	}
+	if c.CertFile != "" {
+		certificate, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	return tlsConfig, nil
+}
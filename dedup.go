@@ -0,0 +1,157 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"unicode"
+)
+
+const (
+	duplicateTypeExact = "exact"
+	duplicateTypeNear  = "near"
+
+	// minHashBands * minHashRows is the length of a min hash signature
+	minHashBands = 16
+	minHashRows  = 4
+
+	// shingleSize is the number of words in one shingle
+	shingleSize = 3
+
+	// nearDuplicateThreshold is the estimated jaccard similarity from which on two reviews are near duplicates
+	nearDuplicateThreshold = 0.8
+
+	// nearDuplicateCandidates limits how many reviews sharing a band are compared
+	nearDuplicateCandidates = 50
+)
+
+var minHashSeeds = makeMinHashSeeds(minHashBands * minHashRows)
+
+// normalizeReviewText lower cases the title and body of a review and reduces them to words
+func normalizeReviewText(review AppReviewGooglePlay) []string {
+	text := strings.ToLower(review.Title + " " + review.Body)
+
+	return strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// reviewFingerprint returns a hash of the normalized review text or an empty string if there is no text
+func reviewFingerprint(words []string) string {
+	if len(words) == 0 {
+		return ""
+	}
+	sum := sha1.Sum([]byte(strings.Join(words, " ")))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// reviewShingles returns the hashes of all word shingles of the text
+func reviewShingles(words []string) []uint64 {
+	if len(words) == 0 {
+		return nil
+	}
+	if len(words) < shingleSize {
+		return []uint64{hashString(strings.Join(words, " "))}
+	}
+
+	shingles := make([]uint64, 0, len(words)-shingleSize+1)
+	for i := 0; i+shingleSize <= len(words); i++ {
+		shingles = append(shingles, hashString(strings.Join(words[i:i+shingleSize], " ")))
+	}
+
+	return shingles
+}
+
+// minHashSignature returns the min hash signature of a set of shingles
+func minHashSignature(shingles []uint64) []uint32 {
+	if len(shingles) == 0 {
+		return nil
+	}
+
+	signature := make([]uint32, len(minHashSeeds))
+	for i, seed := range minHashSeeds {
+		min := ^uint32(0)
+		for _, shingle := range shingles {
+			if h := uint32(splitMix64(shingle ^ seed)); h < min {
+				min = h
+			}
+		}
+		signature[i] = min
+	}
+
+	return signature
+}
+
+// minHashBandKeys returns the locality sensitive hashing keys of a signature.
+// Two signatures sharing a key are candidates for near duplicates.
+func minHashBandKeys(signature []uint32) []string {
+	if len(signature) != minHashBands*minHashRows {
+		return nil
+	}
+
+	keys := make([]string, minHashBands)
+	for band := 0; band < minHashBands; band++ {
+		h := fnv.New64a()
+		for _, v := range signature[band*minHashRows : (band+1)*minHashRows] {
+			h.Write([]byte{byte(v), byte(v >> 8), byte(v >> 16), byte(v >> 24)})
+		}
+		keys[band] = fmt.Sprintf("%d:%x", band, h.Sum64())
+	}
+
+	return keys
+}
+
+// estimateSimilarity returns the estimated jaccard similarity of two min hash signatures
+func estimateSimilarity(a, b []uint32) float64 {
+	if len(a) == 0 || len(a) != len(b) {
+		return 0
+	}
+
+	equal := 0
+	for i := range a {
+		if a[i] == b[i] {
+			equal++
+		}
+	}
+
+	return float64(equal) / float64(len(a))
+}
+
+// fingerprintReview sets the content fingerprint and the min hash signature of a review
+func fingerprintReview(review AppReviewGooglePlay) AppReviewGooglePlay {
+	words := normalizeReviewText(review)
+	review.Fingerprint = reviewFingerprint(words)
+	review.MinHash = minHashSignature(reviewShingles(words))
+	review.MinHashBands = minHashBandKeys(review.MinHash)
+	review.DuplicateOf = ""
+	review.DuplicateType = ""
+
+	return review
+}
+
+func hashString(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+
+	return h.Sum64()
+}
+
+func splitMix64(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+
+	return x ^ (x >> 31)
+}
+
+func makeMinHashSeeds(n int) []uint64 {
+	seeds := make([]uint64, n)
+	for i := range seeds {
+		seeds[i] = splitMix64(uint64(i + 1))
+	}
+
+	return seeds
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	exportFormatCSV       = "csv"
+	exportFormatJSONLines = "jsonl"
+
+	// exportFlushEvery is the number of rows after which an export is flushed to the client
+	exportFlushEvery = 500
+)
+
+var exportContentTypes = map[string]string{
+	exportFormatCSV:       "text/csv",
+	exportFormatJSONLines: "application/x-ndjson",
+}
+
+var appReviewCSVHeader = []string{
+	"review_id", "package_name", "author", "date_posted", "rating", "title", "body", "perma_link",
+	"cluster_is_feature_request", "cluster_is_bug_report", "labels", "fingerprint", "duplicate_of", "duplicate_type",
+}
+
+var appPageCSVHeader = []string{
+	"name", "package_name", "date_crawled", "category", "usk", "price", "price_value", "price_currency",
+	"description", "whats_new", "rating", "stars_count", "count_per_rating_5", "count_per_rating_4",
+	"count_per_rating_3", "count_per_rating_2", "count_per_rating_1", "estimated_download_number", "developer",
+	"top_developer", "contains_ads", "in_app_purchase", "last_update", "os", "requires_os_version",
+	"current_software_version", "similar_apps",
+}
+
+// exportFilter selects the documents of an export, empty fields match everything
+type exportFilter struct {
+	packageName string
+	from        int64
+	to          int64
+}
+
+// flusher is implemented by writers that can push buffered data to the client
+type flusher interface {
+	Flush()
+}
+
+// rowWriter writes exported documents one by one in a specific format
+type rowWriter interface {
+	writeHeader() error
+	writeRow(row []string, document interface{}) error
+	flush() error
+}
+
+type csvRowWriter struct {
+	writer *csv.Writer
+	header []string
+}
+
+func (w *csvRowWriter) writeHeader() error {
+	return w.writer.Write(w.header)
+}
+
+func (w *csvRowWriter) writeRow(row []string, document interface{}) error {
+	return w.writer.Write(row)
+}
+
+func (w *csvRowWriter) flush() error {
+	w.writer.Flush()
+	return w.writer.Error()
+}
+
+type jsonLinesRowWriter struct {
+	encoder *json.Encoder
+}
+
+func (w *jsonLinesRowWriter) writeHeader() error {
+	return nil
+}
+
+func (w *jsonLinesRowWriter) writeRow(row []string, document interface{}) error {
+	return w.encoder.Encode(document)
+}
+
+func (w *jsonLinesRowWriter) flush() error {
+	return nil
+}
+
+// isValidExportFormat returns true if documents can be exported in the given format
+func isValidExportFormat(format string) bool {
+	_, ok := exportContentTypes[format]
+	return ok
+}
+
+func newRowWriter(out io.Writer, format string, header []string) (rowWriter, error) {
+	switch format {
+	case exportFormatCSV:
+		return &csvRowWriter{writer: csv.NewWriter(out), header: header}, nil
+	case exportFormatJSONLines:
+		return &jsonLinesRowWriter{encoder: json.NewEncoder(out)}, nil
+	}
+
+	return nil, fmt.Errorf("unknown export format %q", format)
+}
+
+// writeExportRow writes the count-th document of an export and regularly flushes the output to the client
+func writeExportRow(out io.Writer, writer rowWriter, count int, row []string, document interface{}) error {
+	if err := writer.writeRow(row, document); err != nil {
+		return err
+	}
+	if count%exportFlushEvery != 0 {
+		return nil
+	}
+
+	return flushExport(out, writer)
+}
+
+func flushExport(out io.Writer, writer rowWriter) error {
+	if err := writer.flush(); err != nil {
+		return err
+	}
+	if f, ok := out.(flusher); ok {
+		f.Flush()
+	}
+
+	return nil
+}
+
+// exportAppReviews streams the app reviews matching the filter to out in the given format
+// and returns the number of exported reviews
+func exportAppReviews(mongoClient *storageSession, out io.Writer, format string, filter exportFilter) (int, error) {
+	writer, err := newRowWriter(out, format, appReviewCSVHeader)
+	if err != nil {
+		return 0, err
+	}
+	if err = writer.writeHeader(); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	err = MongoForEachAppReviewGooglePlay(mongoClient, filter.packageName, filter.from, filter.to, func(review AppReviewGooglePlay) error {
+		count++
+		return writeExportRow(out, writer, count, appReviewCSVRow(review), review)
+	})
+	if err != nil {
+		return count, err
+	}
+
+	return count, flushExport(out, writer)
+}
+
+// exportAppPages streams the app pages matching the filter to out in the given format
+// and returns the number of exported app pages
+func exportAppPages(mongoClient *storageSession, out io.Writer, format string, filter exportFilter) (int, error) {
+	writer, err := newRowWriter(out, format, appPageCSVHeader)
+	if err != nil {
+		return 0, err
+	}
+	if err = writer.writeHeader(); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	err = MongoForEachAppPageGooglePlay(mongoClient, filter.packageName, filter.from, filter.to, func(appPage AppPageGooglePlay) error {
+		count++
+		return writeExportRow(out, writer, count, appPageCSVRow(appPage), appPage)
+	})
+	if err != nil {
+		return count, err
+	}
+
+	return count, flushExport(out, writer)
+}
+
+func appReviewCSVRow(review AppReviewGooglePlay) []string {
+	labels := make([]string, len(review.Labels))
+	for i, label := range review.Labels {
+		labels[i] = label.Name + ":" + strconv.FormatFloat(label.Confidence, 'f', -1, 64)
+	}
+
+	return []string{
+		review.ReviewID,
+		review.PackageName,
+		review.Author,
+		strconv.FormatInt(review.Date, 10),
+		strconv.Itoa(review.Rating),
+		review.Title,
+		review.Body,
+		review.PermaLink,
+		strconv.FormatBool(review.FeatureRequest),
+		strconv.FormatBool(review.BugReport),
+		strings.Join(labels, ";"),
+		review.Fingerprint,
+		review.DuplicateOf,
+		review.DuplicateType,
+	}
+}
+
+func appPageCSVRow(appPage AppPageGooglePlay) []string {
+	return []string{
+		appPage.Name,
+		appPage.PackageName,
+		strconv.FormatInt(appPage.DateCrawled, 10),
+		appPage.Category,
+		appPage.USK,
+		appPage.Price,
+		strconv.FormatFloat(appPage.PriceValue, 'f', -1, 64),
+		appPage.PriceCurrency,
+		appPage.Description,
+		strings.Join(appPage.WhatsNew, "\n"),
+		strconv.FormatFloat(appPage.Rating, 'f', -1, 64),
+		strconv.FormatInt(appPage.StarsCount, 10),
+		strconv.Itoa(appPage.CountPerRating.Five),
+		strconv.Itoa(appPage.CountPerRating.Four),
+		strconv.Itoa(appPage.CountPerRating.Three),
+		strconv.Itoa(appPage.CountPerRating.Two),
+		strconv.Itoa(appPage.CountPerRating.One),
+		strconv.FormatInt(appPage.EstimatedDownloadNumber, 10),
+		appPage.DeveloperName,
+		strconv.FormatBool(appPage.TopDeveloper),
+		strconv.FormatBool(appPage.ContainsAds),
+		strconv.FormatBool(appPage.InAppPurchases),
+		strconv.FormatInt(appPage.LastUpdate, 10),
+		appPage.Os,
+		appPage.RequiresOsVersion,
+		appPage.CurrentSoftwareVersion,
+		strings.Join(appPage.SimilarApps, ";"),
+	}
+}
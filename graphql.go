@@ -0,0 +1,1161 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// This file implements the part of GraphQL the API needs: queries with arguments, variables, aliases, fragments and
+// the skip and include directives. Mutations, subscriptions and introspection beyond __typename are not supported.
+
+const (
+	// maxGraphQLDepth is the deepest nesting of fields a query may select
+	maxGraphQLDepth = 6
+	// maxGraphQLCost is the most fields a query may resolve, see gqlExecutor.cost
+	maxGraphQLCost = 20000
+)
+
+const (
+	gqlTokenEOF = iota
+	gqlTokenPunctuator
+	gqlTokenName
+	gqlTokenInt
+	gqlTokenFloat
+	gqlTokenString
+)
+
+type gqlToken struct {
+	kind  int
+	value string
+	pos   int
+}
+
+// gqlSyntaxError is an error in the query document, the request is rejected as a whole
+type gqlSyntaxError struct {
+	message string
+}
+
+func (err gqlSyntaxError) Error() string {
+	return err.message
+}
+
+// gqlRequestError rejects the request as a whole, e.g. because it selects a field the schema does not have
+type gqlRequestError struct {
+	message string
+}
+
+func (err gqlRequestError) Error() string {
+	return err.message
+}
+
+type gqlLexer struct {
+	src string
+	pos int
+}
+
+func (l *gqlLexer) errorf(pos int, format string, a ...interface{}) error {
+	line, column := 1, 1
+	for _, c := range l.src[:pos] {
+		if c == '\n' {
+			line++
+			column = 1
+		} else {
+			column++
+		}
+	}
+
+	return gqlSyntaxError{fmt.Sprintf("syntax error at %d:%d: %s", line, column, fmt.Sprintf(format, a...))}
+}
+
+func isGraphQLNameStart(c byte) bool {
+	return c == '_' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z'
+}
+
+func isGraphQLDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// next returns the next token, skipping white space, commas and comments
+func (l *gqlLexer) next() (gqlToken, error) {
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		if c == '#' {
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' && l.src[l.pos] != '\r' {
+				l.pos++
+			}
+			continue
+		}
+		if c != ' ' && c != '\t' && c != '\n' && c != '\r' && c != ',' {
+			break
+		}
+		l.pos++
+	}
+	start := l.pos
+	if l.pos >= len(l.src) {
+		return gqlToken{kind: gqlTokenEOF, pos: start}, nil
+	}
+
+	c := l.src[l.pos]
+	switch {
+	case strings.HasPrefix(l.src[l.pos:], "..."):
+		l.pos += 3
+		return gqlToken{kind: gqlTokenPunctuator, value: "...", pos: start}, nil
+	case strings.IndexByte("!$()[]{}:=@|&", c) >= 0:
+		l.pos++
+		return gqlToken{kind: gqlTokenPunctuator, value: string(c), pos: start}, nil
+	case isGraphQLNameStart(c):
+		for l.pos < len(l.src) && (isGraphQLNameStart(l.src[l.pos]) || isGraphQLDigit(l.src[l.pos])) {
+			l.pos++
+		}
+		return gqlToken{kind: gqlTokenName, value: l.src[start:l.pos], pos: start}, nil
+	case c == '-' || isGraphQLDigit(c):
+		return l.number(start)
+	case c == '"':
+		return l.string(start)
+	}
+
+	return gqlToken{}, l.errorf(start, "unexpected character %q", c)
+}
+
+func (l *gqlLexer) number(start int) (gqlToken, error) {
+	digits := func() int {
+		from := l.pos
+		for l.pos < len(l.src) && isGraphQLDigit(l.src[l.pos]) {
+			l.pos++
+		}
+		return l.pos - from
+	}
+	kind := gqlTokenInt
+	if l.src[l.pos] == '-' {
+		l.pos++
+	}
+	if digits() == 0 {
+		return gqlToken{}, l.errorf(start, "invalid number")
+	}
+	if l.pos < len(l.src) && l.src[l.pos] == '.' {
+		kind = gqlTokenFloat
+		l.pos++
+		if digits() == 0 {
+			return gqlToken{}, l.errorf(start, "invalid number")
+		}
+	}
+	if l.pos < len(l.src) && (l.src[l.pos] == 'e' || l.src[l.pos] == 'E') {
+		kind = gqlTokenFloat
+		l.pos++
+		if l.pos < len(l.src) && (l.src[l.pos] == '+' || l.src[l.pos] == '-') {
+			l.pos++
+		}
+		if digits() == 0 {
+			return gqlToken{}, l.errorf(start, "invalid number")
+		}
+	}
+
+	return gqlToken{kind: kind, value: l.src[start:l.pos], pos: start}, nil
+}
+
+// string reads a string literal, its escape sequences are those of JSON
+func (l *gqlLexer) string(start int) (gqlToken, error) {
+	if strings.HasPrefix(l.src[l.pos:], `"""`) {
+		end := strings.Index(l.src[l.pos+3:], `"""`)
+		if end < 0 {
+			return gqlToken{}, l.errorf(start, "unterminated string")
+		}
+		value := strings.TrimSpace(strings.ReplaceAll(l.src[l.pos+3:l.pos+3+end], `\"""`, `"""`))
+		l.pos += end + 6
+		return gqlToken{kind: gqlTokenString, value: value, pos: start}, nil
+	}
+
+	l.pos++
+	for l.pos < len(l.src) && l.src[l.pos] != '"' && l.src[l.pos] != '\n' {
+		if l.src[l.pos] == '\\' {
+			l.pos++
+		}
+		l.pos++
+	}
+	if l.pos >= len(l.src) || l.src[l.pos] != '"' {
+		return gqlToken{}, l.errorf(start, "unterminated string")
+	}
+	l.pos++
+	var value string
+	if err := json.Unmarshal([]byte(l.src[start:l.pos]), &value); err != nil {
+		return gqlToken{}, l.errorf(start, "invalid string")
+	}
+
+	return gqlToken{kind: gqlTokenString, value: value, pos: start}, nil
+}
+
+type gqlDocument struct {
+	operations []*gqlOperation
+	fragments  map[string]*gqlFragment
+}
+
+type gqlOperation struct {
+	kind       string
+	name       string
+	variables  []gqlVariableDefinition
+	selections []*gqlSelection
+}
+
+type gqlVariableDefinition struct {
+	name         string
+	nonNull      bool
+	defaultValue interface{}
+	hasDefault   bool
+}
+
+type gqlFragment struct {
+	typeCondition string
+	selections    []*gqlSelection
+}
+
+// gqlSelection is a field, a fragment spread naming a fragment or an inline fragment
+type gqlSelection struct {
+	alias      string
+	name       string
+	arguments  map[string]interface{}
+	directives []gqlDirective
+	selections []*gqlSelection
+
+	fragment      string
+	inline        bool
+	typeCondition string
+}
+
+func (s *gqlSelection) responseKey() string {
+	if s.alias != "" {
+		return s.alias
+	}
+
+	return s.name
+}
+
+type gqlDirective struct {
+	name      string
+	arguments map[string]interface{}
+}
+
+// gqlVariable is a reference to a variable in an argument value
+type gqlVariable string
+
+// gqlEnum is an enum value, the schema has no enums but accepts them where strings are expected
+type gqlEnum string
+
+type gqlParser struct {
+	lexer gqlLexer
+	token gqlToken
+	// err is the error of the lexer, the token is the end of the document then
+	err error
+}
+
+// parseGraphQL parses a query document
+func parseGraphQL(query string) (*gqlDocument, error) {
+	p := &gqlParser{lexer: gqlLexer{src: query}}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	document := &gqlDocument{fragments: map[string]*gqlFragment{}}
+	for p.token.kind != gqlTokenEOF {
+		switch {
+		case p.peek("{"):
+			selections, err := p.parseSelectionSet()
+			if err != nil {
+				return nil, err
+			}
+			document.operations = append(document.operations, &gqlOperation{kind: "query", selections: selections})
+		case p.token.kind == gqlTokenName && p.token.value == "fragment":
+			name, fragment, err := p.parseFragment()
+			if err != nil {
+				return nil, err
+			}
+			document.fragments[name] = fragment
+		case p.token.kind == gqlTokenName:
+			operation, err := p.parseOperation()
+			if err != nil {
+				return nil, err
+			}
+			document.operations = append(document.operations, operation)
+		default:
+			return nil, p.unexpected()
+		}
+	}
+	if len(document.operations) == 0 {
+		return nil, gqlSyntaxError{"the document contains no operation"}
+	}
+
+	return document, nil
+}
+
+func (p *gqlParser) advance() error {
+	token, err := p.lexer.next()
+	if err != nil {
+		p.err = err
+		token = gqlToken{kind: gqlTokenEOF, pos: p.lexer.pos}
+	}
+	p.token = token
+
+	return err
+}
+
+func (p *gqlParser) peek(punctuator string) bool {
+	return p.token.kind == gqlTokenPunctuator && p.token.value == punctuator
+}
+
+func (p *gqlParser) unexpected() error {
+	if p.err != nil {
+		return p.err
+	}
+	if p.token.kind == gqlTokenEOF {
+		return p.lexer.errorf(p.token.pos, "unexpected end of the document")
+	}
+
+	return p.lexer.errorf(p.token.pos, "unexpected %q", p.token.value)
+}
+
+func (p *gqlParser) expect(punctuator string) error {
+	if !p.peek(punctuator) {
+		return p.unexpected()
+	}
+
+	return p.advance()
+}
+
+func (p *gqlParser) name() (string, error) {
+	if p.token.kind != gqlTokenName {
+		return "", p.unexpected()
+	}
+	name := p.token.value
+
+	return name, p.advance()
+}
+
+func (p *gqlParser) parseOperation() (*gqlOperation, error) {
+	kind, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	if kind != "query" && kind != "mutation" && kind != "subscription" {
+		return nil, p.lexer.errorf(p.token.pos, "unknown operation type %q", kind)
+	}
+	operation := &gqlOperation{kind: kind}
+	if p.token.kind == gqlTokenName {
+		operation.name, _ = p.name()
+	}
+	if p.peek("(") {
+		if operation.variables, err = p.parseVariableDefinitions(); err != nil {
+			return nil, err
+		}
+	}
+	if _, err = p.parseDirectives(); err != nil {
+		return nil, err
+	}
+	operation.selections, err = p.parseSelectionSet()
+
+	return operation, err
+}
+
+func (p *gqlParser) parseVariableDefinitions() ([]gqlVariableDefinition, error) {
+	var definitions []gqlVariableDefinition
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	for !p.peek(")") {
+		if err := p.expect("$"); err != nil {
+			return nil, err
+		}
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		if err = p.expect(":"); err != nil {
+			return nil, err
+		}
+		nonNull, err := p.parseType()
+		if err != nil {
+			return nil, err
+		}
+		definition := gqlVariableDefinition{name: name, nonNull: nonNull}
+		if p.peek("=") {
+			if err = p.advance(); err != nil {
+				return nil, err
+			}
+			if definition.defaultValue, err = p.parseValue(true); err != nil {
+				return nil, err
+			}
+			definition.hasDefault = true
+		}
+		definitions = append(definitions, definition)
+	}
+
+	return definitions, p.advance()
+}
+
+// parseType skips a type reference and returns whether it is non-null, the arguments check the types of the values
+func (p *gqlParser) parseType() (nonNull bool, err error) {
+	if p.peek("[") {
+		if err = p.advance(); err != nil {
+			return false, err
+		}
+		if _, err = p.parseType(); err != nil {
+			return false, err
+		}
+		if err = p.expect("]"); err != nil {
+			return false, err
+		}
+	} else if _, err = p.name(); err != nil {
+		return false, err
+	}
+	if p.peek("!") {
+		return true, p.advance()
+	}
+
+	return false, nil
+}
+
+func (p *gqlParser) parseFragment() (string, *gqlFragment, error) {
+	if err := p.advance(); err != nil {
+		return "", nil, err
+	}
+	name, err := p.name()
+	if err != nil {
+		return "", nil, err
+	}
+	if on, err := p.name(); err != nil || on != "on" {
+		return "", nil, p.lexer.errorf(p.token.pos, "expected on")
+	}
+	fragment := &gqlFragment{}
+	if fragment.typeCondition, err = p.name(); err != nil {
+		return "", nil, err
+	}
+	if _, err = p.parseDirectives(); err != nil {
+		return "", nil, err
+	}
+	fragment.selections, err = p.parseSelectionSet()
+
+	return name, fragment, err
+}
+
+func (p *gqlParser) parseSelectionSet() ([]*gqlSelection, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	var selections []*gqlSelection
+	for !p.peek("}") {
+		selection, err := p.parseSelection()
+		if err != nil {
+			return nil, err
+		}
+		selections = append(selections, selection)
+	}
+
+	return selections, p.advance()
+}
+
+func (p *gqlParser) parseSelection() (*gqlSelection, error) {
+	var err error
+	selection := &gqlSelection{}
+	if p.peek("...") {
+		if err = p.advance(); err != nil {
+			return nil, err
+		}
+		if p.token.kind == gqlTokenName && p.token.value != "on" {
+			selection.fragment, _ = p.name()
+			selection.directives, err = p.parseDirectives()
+			return selection, err
+		}
+		selection.inline = true
+		if p.token.kind == gqlTokenName {
+			p.advance()
+			if selection.typeCondition, err = p.name(); err != nil {
+				return nil, err
+			}
+		}
+		if selection.directives, err = p.parseDirectives(); err != nil {
+			return nil, err
+		}
+		selection.selections, err = p.parseSelectionSet()
+		return selection, err
+	}
+
+	if selection.name, err = p.name(); err != nil {
+		return nil, err
+	}
+	if p.peek(":") {
+		selection.alias = selection.name
+		p.advance()
+		if selection.name, err = p.name(); err != nil {
+			return nil, err
+		}
+	}
+	if p.peek("(") {
+		if selection.arguments, err = p.parseArguments(false); err != nil {
+			return nil, err
+		}
+	}
+	if selection.directives, err = p.parseDirectives(); err != nil {
+		return nil, err
+	}
+	if p.peek("{") {
+		selection.selections, err = p.parseSelectionSet()
+	}
+
+	return selection, err
+}
+
+func (p *gqlParser) parseArguments(constant bool) (map[string]interface{}, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	arguments := map[string]interface{}{}
+	for !p.peek(")") {
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		if err = p.expect(":"); err != nil {
+			return nil, err
+		}
+		if arguments[name], err = p.parseValue(constant); err != nil {
+			return nil, err
+		}
+	}
+
+	return arguments, p.advance()
+}
+
+func (p *gqlParser) parseDirectives() ([]gqlDirective, error) {
+	var directives []gqlDirective
+	for p.peek("@") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		directive := gqlDirective{name: name}
+		if p.peek("(") {
+			if directive.arguments, err = p.parseArguments(false); err != nil {
+				return nil, err
+			}
+		}
+		directives = append(directives, directive)
+	}
+
+	return directives, nil
+}
+
+// parseValue returns an int64, float64, string, bool, nil, gqlEnum, gqlVariable, list or object. Constant values,
+// e.g. defaults of variables, must not contain variables.
+func (p *gqlParser) parseValue(constant bool) (interface{}, error) {
+	token := p.token
+	switch {
+	case p.peek("$") && !constant:
+		p.advance()
+		name, err := p.name()
+		return gqlVariable(name), err
+	case p.peek("["):
+		p.advance()
+		list := []interface{}{}
+		for !p.peek("]") {
+			value, err := p.parseValue(constant)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
+		}
+		return list, p.advance()
+	case p.peek("{"):
+		p.advance()
+		object := map[string]interface{}{}
+		for !p.peek("}") {
+			name, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			if err = p.expect(":"); err != nil {
+				return nil, err
+			}
+			if object[name], err = p.parseValue(constant); err != nil {
+				return nil, err
+			}
+		}
+		return object, p.advance()
+	case token.kind == gqlTokenInt:
+		value, err := strconv.ParseInt(token.value, 10, 64)
+		if err != nil {
+			return nil, p.lexer.errorf(token.pos, "%s is out of range", token.value)
+		}
+		return value, p.advance()
+	case token.kind == gqlTokenFloat:
+		value, err := strconv.ParseFloat(token.value, 64)
+		if err != nil {
+			return nil, p.lexer.errorf(token.pos, "%s is out of range", token.value)
+		}
+		return value, p.advance()
+	case token.kind == gqlTokenString:
+		return token.value, p.advance()
+	case token.kind == gqlTokenName:
+		p.advance()
+		switch token.value {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		}
+		return gqlEnum(token.value), nil
+	}
+
+	return nil, p.unexpected()
+}
+
+// gqlObjectType is an object type of the schema
+type gqlObjectType struct {
+	name   string
+	fields map[string]*gqlField
+	// order lists the field names in the order they are declared
+	order []string
+}
+
+func (t *gqlObjectType) addField(field *gqlField) {
+	t.fields[field.name] = field
+	t.order = append(t.order, field.name)
+}
+
+// gqlType is the type of a field, a scalar, an object type or a list
+type gqlType struct {
+	scalar  string
+	object  *gqlObjectType
+	elem    *gqlType
+	nonNull bool
+}
+
+func (t *gqlType) String() string {
+	name := t.scalar
+	if t.object != nil {
+		name = t.object.name
+	}
+	if t.elem != nil {
+		name = "[" + t.elem.String() + "]"
+	}
+	if t.nonNull {
+		name += "!"
+	}
+
+	return name
+}
+
+type gqlField struct {
+	name      string
+	typ       *gqlType
+	arguments []gqlArgument
+	resolve   func(e *gqlExecutor, source interface{}, args map[string]interface{}) (interface{}, error)
+}
+
+// gqlArgument is a scalar argument of a field
+type gqlArgument struct {
+	name    string
+	scalar  string
+	nonNull bool
+}
+
+// GraphQL scalar types
+const (
+	gqlString  = "String"
+	gqlInt     = "Int"
+	gqlFloat   = "Float"
+	gqlBoolean = "Boolean"
+)
+
+// gqlResult is an object of the response, its fields keep the order of the selections
+type gqlResult struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func (r *gqlResult) set(key string, value interface{}) {
+	if _, ok := r.values[key]; !ok {
+		r.keys = append(r.keys, key)
+	}
+	r.values[key] = value
+}
+
+func (r *gqlResult) MarshalJSON() ([]byte, error) {
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, key := range r.keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		k, _ := json.Marshal(key)
+		v, err := json.Marshal(r.values[key])
+		if err != nil {
+			return nil, err
+		}
+		b.Write(k)
+		b.WriteByte(':')
+		b.Write(v)
+	}
+	b.WriteByte('}')
+
+	return []byte(b.String()), nil
+}
+
+// gqlExecutor executes an operation with the session of the request
+type gqlExecutor struct {
+	m         *storageSession
+	document  *gqlDocument
+	variables map[string]interface{}
+	// declared are the variables of the operation
+	declared map[string]bool
+	// loaded holds the data the resolvers read once per request instead of once per object
+	loaded map[string]interface{}
+	errors []GraphQLError
+}
+
+// executeGraphQL runs the operation of the query document against the query type. Request errors, e.g. syntax errors
+// or unknown fields, are returned as error, the errors of single fields are part of the response.
+func executeGraphQL(m *storageSession, query *gqlObjectType, request GraphQLRequest) (GraphQLResponse, error) {
+	document, err := parseGraphQL(request.Query)
+	if err != nil {
+		return GraphQLResponse{}, err
+	}
+	operation, err := document.operation(request.OperationName)
+	if err != nil {
+		return GraphQLResponse{}, err
+	}
+	e := &gqlExecutor{m: m, document: document, variables: map[string]interface{}{}, declared: map[string]bool{}, loaded: map[string]interface{}{}}
+	for _, definition := range operation.variables {
+		e.declared[definition.name] = true
+		value, ok := request.Variables[definition.name]
+		if !ok && definition.hasDefault {
+			value, ok = definition.defaultValue, true
+		}
+		if definition.nonNull && (!ok || value == nil) {
+			return GraphQLResponse{}, gqlRequestError{fmt.Sprintf("variable $%s is required", definition.name)}
+		}
+		if ok {
+			e.variables[definition.name] = value
+		}
+	}
+
+	cost, err := e.cost(query, operation.selections, 1, map[string]bool{})
+	if err != nil {
+		return GraphQLResponse{}, err
+	}
+	if cost > maxGraphQLCost {
+		return GraphQLResponse{}, gqlRequestError{fmt.Sprintf("the query may resolve %d fields, at most %d are allowed", cost, maxGraphQLCost)}
+	}
+
+	data, err := e.executeSelections(query, nil, operation.selections, nil)
+	if err != nil {
+		return GraphQLResponse{}, err
+	}
+
+	return GraphQLResponse{Data: data, Errors: e.errors}, nil
+}
+
+// cost checks the selections before they are executed and returns the number of fields they may resolve. Every field
+// counts once per object it is selected on; a list is assumed to hold as many objects as its limit argument allows,
+// defaultPageLimit without one. The fields must not be nested deeper than maxGraphQLDepth and may only use declared
+// variables. The fragments on the stack detect fragments spreading themselves.
+func (e *gqlExecutor) cost(object *gqlObjectType, selections []*gqlSelection, depth int, stack map[string]bool) (int, error) {
+	cost := 0
+	for _, selection := range selections {
+		if err := e.checkVariables(selection); err != nil {
+			return 0, err
+		}
+		switch {
+		case selection.fragment != "":
+			fragment, ok := e.document.fragments[selection.fragment]
+			if !ok {
+				return 0, gqlRequestError{fmt.Sprintf("unknown fragment %q", selection.fragment)}
+			}
+			if stack[selection.fragment] {
+				return 0, gqlRequestError{fmt.Sprintf("fragment %q spreads itself", selection.fragment)}
+			}
+			stack[selection.fragment] = true
+			fragmentCost, err := e.cost(object, fragment.selections, depth, stack)
+			delete(stack, selection.fragment)
+			if err != nil {
+				return 0, err
+			}
+			cost += fragmentCost
+		case selection.inline:
+			inlineCost, err := e.cost(object, selection.selections, depth, stack)
+			if err != nil {
+				return 0, err
+			}
+			cost += inlineCost
+		default:
+			if depth > maxGraphQLDepth {
+				return 0, gqlRequestError{fmt.Sprintf("the query nests fields deeper than %d levels", maxGraphQLDepth)}
+			}
+			field, ok := object.fields[selection.name]
+			if !ok {
+				// __typename and unknown fields resolve nothing, the execution rejects the unknown ones
+				continue
+			}
+			cost++
+			if child := baseType(field.typ).object; child != nil {
+				childCost, err := e.cost(child, selection.selections, depth+1, stack)
+				if err != nil {
+					return 0, err
+				}
+				cost += e.listLength(field, selection) * childCost
+			}
+		}
+		if cost > maxGraphQLCost {
+			return cost, nil
+		}
+	}
+
+	return cost, nil
+}
+
+// listLength returns how many objects a field may resolve to, 1 if it is no list
+func (e *gqlExecutor) listLength(field *gqlField, selection *gqlSelection) int {
+	if field.typ.elem == nil {
+		return 1
+	}
+	if limit, err := e.coerce(gqlArgument{name: "limit", scalar: gqlInt}, selection.arguments); err == nil && limit != nil {
+		if n := limit.(int); n >= 0 && n <= maxPageLimit {
+			return n
+		}
+		return maxPageLimit
+	}
+
+	return defaultPageLimit
+}
+
+// checkVariables rejects the variables of the arguments and directives of a selection the operation does not declare
+func (e *gqlExecutor) checkVariables(selection *gqlSelection) error {
+	arguments := []map[string]interface{}{selection.arguments}
+	for _, directive := range selection.directives {
+		arguments = append(arguments, directive.arguments)
+	}
+	for _, values := range arguments {
+		if err := e.checkValueVariables(values); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkValueVariables rejects the undeclared variables of a value, including the items of lists and objects
+func (e *gqlExecutor) checkValueVariables(value interface{}) error {
+	switch v := value.(type) {
+	case gqlVariable:
+		if !e.declared[string(v)] {
+			return gqlRequestError{fmt.Sprintf("variable $%s is not declared", v)}
+		}
+	case []interface{}:
+		for _, item := range v {
+			if err := e.checkValueVariables(item); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		for _, item := range v {
+			if err := e.checkValueVariables(item); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// operation returns the operation to execute, the named one or the only one of the document
+func (d *gqlDocument) operation(name string) (*gqlOperation, error) {
+	if name == "" && len(d.operations) > 1 {
+		return nil, gqlRequestError{"operationName is required if the document contains several operations"}
+	}
+	var operation *gqlOperation
+	for _, o := range d.operations {
+		if name == "" || o.name == name {
+			operation = o
+		}
+	}
+	if operation == nil {
+		return nil, gqlRequestError{fmt.Sprintf("unknown operation %q", name)}
+	}
+	if operation.kind != "query" {
+		return nil, gqlRequestError{fmt.Sprintf("%s operations are not supported", operation.kind)}
+	}
+
+	return operation, nil
+}
+
+func (e *gqlExecutor) executeSelections(object *gqlObjectType, source interface{}, selections []*gqlSelection, path []interface{}) (*gqlResult, error) {
+	fields := &gqlCollectedFields{byKey: map[string][]*gqlSelection{}}
+	if err := e.collectFields(object, selections, fields, map[string]bool{}); err != nil {
+		return nil, err
+	}
+
+	result := &gqlResult{values: map[string]interface{}{}}
+	for _, key := range fields.keys {
+		selection := fields.byKey[key][0]
+		if selection.name == "__typename" {
+			result.set(key, object.name)
+			continue
+		}
+		field, ok := object.fields[selection.name]
+		if !ok {
+			return nil, gqlRequestError{fmt.Sprintf("cannot query field %q on type %s", selection.name, object.name)}
+		}
+		args, err := e.coerceArguments(field, selection.arguments)
+		if err != nil {
+			return nil, err
+		}
+		var subselections []*gqlSelection
+		for _, s := range fields.byKey[key] {
+			subselections = append(subselections, s.selections...)
+		}
+		if baseType(field.typ).object != nil && len(subselections) == 0 {
+			return nil, gqlRequestError{fmt.Sprintf("field %q of type %s must have a selection of subfields", selection.name, field.typ)}
+		}
+		if baseType(field.typ).object == nil && len(subselections) > 0 {
+			return nil, gqlRequestError{fmt.Sprintf("field %q of type %s must not have a selection of subfields", selection.name, field.typ)}
+		}
+
+		fieldPath := append(append([]interface{}{}, path...), key)
+		value, err := field.resolve(e, source, args)
+		if err != nil {
+			e.errors = append(e.errors, GraphQLError{Message: err.Error(), Path: fieldPath})
+			result.set(key, nil)
+			continue
+		}
+		completed, err := e.complete(field.typ, value, subselections, fieldPath)
+		if err != nil {
+			return nil, err
+		}
+		result.set(key, completed)
+	}
+
+	return result, nil
+}
+
+func baseType(t *gqlType) *gqlType {
+	for t.elem != nil {
+		t = t.elem
+	}
+
+	return t
+}
+
+// complete turns the value of a field into its response value, executing the selections of objects
+func (e *gqlExecutor) complete(t *gqlType, value interface{}, selections []*gqlSelection, path []interface{}) (interface{}, error) {
+	v := reflect.ValueOf(value)
+	if value == nil || (v.Kind() == reflect.Ptr || v.Kind() == reflect.Slice) && v.IsNil() {
+		if t.elem != nil && t.nonNull {
+			return []interface{}{}, nil
+		}
+		return nil, nil
+	}
+	if t.elem != nil {
+		list := make([]interface{}, v.Len())
+		for i := range list {
+			item, err := e.complete(t.elem, v.Index(i).Interface(), selections, append(append([]interface{}{}, path...), i))
+			if err != nil {
+				return nil, err
+			}
+			list[i] = item
+		}
+		return list, nil
+	}
+	if t.object != nil {
+		return e.executeSelections(t.object, value, selections, path)
+	}
+
+	return value, nil
+}
+
+type gqlCollectedFields struct {
+	keys  []string
+	byKey map[string][]*gqlSelection
+}
+
+// collectFields groups the fields of the selections by their response keys, expanding the fragments that apply to
+// the object type and leaving out the selections skipped by a directive
+func (e *gqlExecutor) collectFields(object *gqlObjectType, selections []*gqlSelection, fields *gqlCollectedFields, visited map[string]bool) error {
+	for _, selection := range selections {
+		include, err := e.included(selection.directives)
+		if err != nil {
+			return err
+		}
+		if !include {
+			continue
+		}
+		switch {
+		case selection.fragment != "":
+			fragment, ok := e.document.fragments[selection.fragment]
+			if !ok {
+				return gqlRequestError{fmt.Sprintf("unknown fragment %q", selection.fragment)}
+			}
+			if visited[selection.fragment] || fragment.typeCondition != object.name {
+				continue
+			}
+			visited[selection.fragment] = true
+			if err = e.collectFields(object, fragment.selections, fields, visited); err != nil {
+				return err
+			}
+		case selection.inline:
+			if selection.typeCondition != "" && selection.typeCondition != object.name {
+				continue
+			}
+			if err = e.collectFields(object, selection.selections, fields, visited); err != nil {
+				return err
+			}
+		default:
+			key := selection.responseKey()
+			if _, ok := fields.byKey[key]; !ok {
+				fields.keys = append(fields.keys, key)
+			}
+			fields.byKey[key] = append(fields.byKey[key], selection)
+		}
+	}
+
+	return nil
+}
+
+// included evaluates the skip and include directives
+func (e *gqlExecutor) included(directives []gqlDirective) (bool, error) {
+	for _, directive := range directives {
+		if directive.name != "skip" && directive.name != "include" {
+			return false, gqlRequestError{fmt.Sprintf("unknown directive @%s", directive.name)}
+		}
+		value, err := e.coerce(gqlArgument{name: "if", scalar: gqlBoolean, nonNull: true}, directive.arguments)
+		if err != nil {
+			return false, err
+		}
+		if value.(bool) == (directive.name == "skip") {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+func (e *gqlExecutor) coerceArguments(field *gqlField, arguments map[string]interface{}) (map[string]interface{}, error) {
+	args := map[string]interface{}{}
+	for name := range arguments {
+		known := false
+		for _, argument := range field.arguments {
+			known = known || argument.name == name
+		}
+		if !known {
+			return nil, gqlRequestError{fmt.Sprintf("unknown argument %q of field %q", name, field.name)}
+		}
+	}
+	for _, argument := range field.arguments {
+		value, err := e.coerce(argument, arguments)
+		if err != nil {
+			return nil, err
+		}
+		if value != nil {
+			args[argument.name] = value
+		}
+	}
+
+	return args, nil
+}
+
+// coerce returns the value of the argument as string, int, float64 or bool, nil if it is not given
+func (e *gqlExecutor) coerce(argument gqlArgument, arguments map[string]interface{}) (interface{}, error) {
+	value := arguments[argument.name]
+	if variable, ok := value.(gqlVariable); ok {
+		value = e.variables[string(variable)]
+	}
+	if value == nil {
+		if argument.nonNull {
+			return nil, gqlRequestError{fmt.Sprintf("argument %q is required", argument.name)}
+		}
+		return nil, nil
+	}
+
+	invalid := gqlRequestError{fmt.Sprintf("argument %q must be of type %s", argument.name, argument.scalar)}
+	switch argument.scalar {
+	case gqlString:
+		switch v := value.(type) {
+		case string:
+			return v, nil
+		case gqlEnum:
+			return string(v), nil
+		}
+	case gqlInt:
+		switch v := value.(type) {
+		case int64:
+			return int(v), nil
+		case float64:
+			// variables are decoded from JSON as float64
+			if v == float64(int(v)) {
+				return int(v), nil
+			}
+		}
+	case gqlFloat:
+		switch v := value.(type) {
+		case int64:
+			return float64(v), nil
+		case float64:
+			return v, nil
+		}
+	case gqlBoolean:
+		if v, ok := value.(bool); ok {
+			return v, nil
+		}
+	}
+
+	return nil, invalid
+}
+
+// graphQLSchemaDefinition returns the schema of the query type in the GraphQL schema definition language
+func graphQLSchemaDefinition(query *gqlObjectType) string {
+	var types []*gqlObjectType
+	seen := map[string]bool{}
+	var visit func(t *gqlObjectType)
+	visit = func(t *gqlObjectType) {
+		if seen[t.name] {
+			return
+		}
+		seen[t.name] = true
+		types = append(types, t)
+		for _, name := range t.order {
+			if object := baseType(t.fields[name].typ).object; object != nil {
+				visit(object)
+			}
+		}
+	}
+	visit(query)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "schema {\n  query: %s\n}\n", query.name)
+	for _, t := range types {
+		fmt.Fprintf(&b, "\ntype %s {\n", t.name)
+		for _, name := range t.order {
+			field := t.fields[name]
+			var arguments []string
+			for _, argument := range field.arguments {
+				typ := argument.scalar
+				if argument.nonNull {
+					typ += "!"
+				}
+				arguments = append(arguments, argument.name+": "+typ)
+			}
+			if len(arguments) > 0 {
+				fmt.Fprintf(&b, "  %s(%s): %s\n", name, strings.Join(arguments, ", "), field.typ)
+			} else {
+				fmt.Fprintf(&b, "  %s: %s\n", name, field.typ)
+			}
+		}
+		b.WriteString("}\n")
+	}
+
+	return b.String()
+}
@@ -0,0 +1,400 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// graphQLApp is the App object of the GraphQL schema, its fields resolve the stored data of the package
+type graphQLApp struct {
+	packageName string
+}
+
+var (
+	pageArguments = []gqlArgument{
+		{name: "offset", scalar: gqlInt},
+		{name: "limit", scalar: gqlInt},
+	}
+	dateRangeArguments = []gqlArgument{
+		{name: "from", scalar: gqlInt},
+		{name: "to", scalar: gqlInt},
+	}
+)
+
+// graphQLQuery is the query type of the GraphQL endpoint
+var graphQLQuery = newGraphQLSchema()
+
+// newGraphQLSchema builds the query type. The object types of the models are derived from their json fields, so that
+// the GraphQL endpoint answers with the same field names as the REST routes.
+func newGraphQLSchema() *gqlObjectType {
+	types := map[string]*gqlObjectType{}
+	appPage := modelObjectType(reflect.TypeOf(AppPageGooglePlay{}), types)
+	appReview := modelObjectType(reflect.TypeOf(AppReviewGooglePlay{}), types)
+	observable := modelObjectType(reflect.TypeOf(ObservableGooglePlay{}), types)
+	statistics := modelObjectType(reflect.TypeOf(StatisticsGooglePlay{}), types)
+
+	app := &gqlObjectType{name: "App", fields: map[string]*gqlField{}}
+	app.addField(&gqlField{
+		name: "package_name",
+		typ:  &gqlType{scalar: gqlString, nonNull: true},
+		resolve: func(e *gqlExecutor, source interface{}, args map[string]interface{}) (interface{}, error) {
+			return source.(graphQLApp).packageName, nil
+		},
+	})
+	app.addField(&gqlField{
+		name:    "observable",
+		typ:     &gqlType{object: observable},
+		resolve: resolveAppObservable,
+	})
+	app.addField(&gqlField{
+		name:    "latest_page",
+		typ:     &gqlType{object: appPage},
+		resolve: resolveAppLatestPage,
+	})
+	app.addField(&gqlField{
+		name:      "pages",
+		typ:       listOf(appPage),
+		arguments: append(append([]gqlArgument{}, dateRangeArguments...), pageArguments...),
+		resolve:   resolveAppPages,
+	})
+	reviewArguments := []gqlArgument{
+		{name: "class", scalar: gqlString},
+		{name: "min_confidence", scalar: gqlFloat},
+		{name: "exclude_duplicates", scalar: gqlBoolean},
+	}
+	reviewArguments = append(append(reviewArguments, dateRangeArguments...), pageArguments...)
+	app.addField(&gqlField{
+		name:      "reviews",
+		typ:       listOf(appReview),
+		arguments: reviewArguments,
+		resolve:   resolveAppReviews,
+	})
+	app.addField(&gqlField{
+		name:    "statistics",
+		typ:     &gqlType{object: statistics},
+		resolve: resolveAppStatistics,
+	})
+
+	query := &gqlObjectType{name: "Query", fields: map[string]*gqlField{}}
+	query.addField(&gqlField{
+		name:      "app",
+		typ:       &gqlType{object: app, nonNull: true},
+		arguments: []gqlArgument{{name: "package_name", scalar: gqlString, nonNull: true}},
+		resolve:   resolveApp,
+	})
+	query.addField(&gqlField{
+		name:      "apps",
+		typ:       listOf(app),
+		arguments: pageArguments,
+		resolve:   resolveApps,
+	})
+	query.addField(&gqlField{
+		name:    "observables",
+		typ:     listOf(observable),
+		resolve: resolveObservables,
+	})
+
+	return query
+}
+
+// listOf returns the type of a list of objects that is never null
+func listOf(object *gqlObjectType) *gqlType {
+	return &gqlType{elem: &gqlType{object: object, nonNull: true}, nonNull: true}
+}
+
+// modelObjectType returns the object type of a model named after the model. Fields whose json names are no GraphQL
+// names, like the star counts, are named after the lower case field.
+func modelObjectType(t reflect.Type, types map[string]*gqlObjectType) *gqlObjectType {
+	if object, ok := types[t.Name()]; ok {
+		return object
+	}
+	object := &gqlObjectType{name: t.Name(), fields: map[string]*gqlField{}}
+	types[t.Name()] = object
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if field.PkgPath != "" || name == "-" {
+			continue
+		}
+		if name == "" || !isGraphQLName(name) {
+			name = strings.ToLower(field.Name)
+		}
+		typ := modelFieldType(field.Type, types)
+		if typ == nil {
+			continue
+		}
+		index := i
+		object.addField(&gqlField{
+			name: name,
+			typ:  typ,
+			resolve: func(e *gqlExecutor, source interface{}, args map[string]interface{}) (interface{}, error) {
+				return reflect.Indirect(reflect.ValueOf(source)).Field(index).Interface(), nil
+			},
+		})
+	}
+
+	return object
+}
+
+// modelFieldType returns the GraphQL type of a field of a model, nil if GraphQL cannot express it
+func modelFieldType(t reflect.Type, types map[string]*gqlObjectType) *gqlType {
+	switch t.Kind() {
+	case reflect.String:
+		return &gqlType{scalar: gqlString, nonNull: true}
+	case reflect.Bool:
+		return &gqlType{scalar: gqlBoolean, nonNull: true}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &gqlType{scalar: gqlInt, nonNull: true}
+	case reflect.Float32, reflect.Float64:
+		return &gqlType{scalar: gqlFloat, nonNull: true}
+	case reflect.Struct:
+		return &gqlType{object: modelObjectType(t, types), nonNull: true}
+	case reflect.Ptr:
+		elem := modelFieldType(t.Elem(), types)
+		if elem != nil {
+			elem.nonNull = false
+		}
+		return elem
+	case reflect.Slice:
+		elem := modelFieldType(t.Elem(), types)
+		if elem == nil {
+			return nil
+		}
+		return &gqlType{elem: elem}
+	}
+
+	return nil
+}
+
+func isGraphQLName(name string) bool {
+	for i := 0; i < len(name); i++ {
+		if !isGraphQLNameStart(name[i]) && (i == 0 || !isGraphQLDigit(name[i])) {
+			return false
+		}
+	}
+
+	return name != ""
+}
+
+// graphQLPage returns the offset and limit arguments of a list, limited like the lists of the v2 API
+func graphQLPage(args map[string]interface{}) (offset, limit int, err error) {
+	offset, limit = 0, defaultPageLimit
+	if value, ok := args["offset"]; ok {
+		offset = value.(int)
+	}
+	if value, ok := args["limit"]; ok {
+		limit = value.(int)
+	}
+	if offset < 0 {
+		return 0, 0, errors.New("offset must not be negative")
+	}
+	if limit < 1 || limit > maxPageLimit {
+		return 0, 0, fmt.Errorf("limit must be between 1 and %d", maxPageLimit)
+	}
+
+	return offset, limit, nil
+}
+
+// graphQLDateRange returns the from and to arguments, 0 if they are not given
+func graphQLDateRange(args map[string]interface{}) (from, to int64, err error) {
+	if value, ok := args["from"]; ok {
+		from = int64(value.(int))
+	}
+	if value, ok := args["to"]; ok {
+		to = int64(value.(int))
+	}
+	if _, ok := parseDate(from); from != 0 && !ok {
+		return 0, 0, errors.New("from must be a date like 20191101")
+	}
+	if _, ok := parseDate(to); to != 0 && !ok {
+		return 0, 0, errors.New("to must be a date like 20191101")
+	}
+
+	return from, to, nil
+}
+
+func resolveApp(e *gqlExecutor, source interface{}, args map[string]interface{}) (interface{}, error) {
+	packageName := args["package_name"].(string)
+	if !packageNamePattern.MatchString(packageName) {
+		return nil, fmt.Errorf("%q is not a package name like com.example.app", packageName)
+	}
+
+	return graphQLApp{packageName: packageName}, nil
+}
+
+// graphQLObservables are the observed apps of a request, read once and indexed by package name
+type graphQLObservables struct {
+	all       []ObservableGooglePlay
+	byPackage map[string]ObservableGooglePlay
+}
+
+// loadObservables returns the observed apps, they are read once per request however many apps select them
+func loadObservables(e *gqlExecutor) graphQLObservables {
+	if observables, ok := e.loaded["observables"].(graphQLObservables); ok {
+		return observables
+	}
+	observables := graphQLObservables{all: MongoGetAllObservableGooglePlay(e.m), byPackage: map[string]ObservableGooglePlay{}}
+	for _, observable := range observables.all {
+		observables.byPackage[observable.PackageName] = observable
+	}
+	e.loaded["observables"] = observables
+
+	return observables
+}
+
+// resolveApps returns the observed apps
+func resolveApps(e *gqlExecutor, source interface{}, args map[string]interface{}) (interface{}, error) {
+	offset, limit, err := graphQLPage(args)
+	if err != nil {
+		return nil, err
+	}
+	apps := []graphQLApp{}
+	for i, observable := range loadObservables(e).all {
+		if i >= offset && len(apps) < limit {
+			apps = append(apps, graphQLApp{packageName: observable.PackageName})
+		}
+	}
+
+	return apps, nil
+}
+
+func resolveObservables(e *gqlExecutor, source interface{}, args map[string]interface{}) (interface{}, error) {
+	return loadObservables(e).all, nil
+}
+
+func resolveAppObservable(e *gqlExecutor, source interface{}, args map[string]interface{}) (interface{}, error) {
+	observable, ok := loadObservables(e).byPackage[source.(graphQLApp).packageName]
+	if !ok {
+		return nil, nil
+	}
+
+	return observable, nil
+}
+
+func resolveAppLatestPage(e *gqlExecutor, source interface{}, args map[string]interface{}) (interface{}, error) {
+	appPages, ok := MongoFindAppPagesGooglePlay(e.m, source.(graphQLApp).packageName, 0, 0, 0, 1)
+	if !ok {
+		return nil, errors.New("could not read the app pages")
+	}
+	if len(appPages) == 0 {
+		return nil, nil
+	}
+
+	return appPages[0], nil
+}
+
+func resolveAppPages(e *gqlExecutor, source interface{}, args map[string]interface{}) (interface{}, error) {
+	from, to, err := graphQLDateRange(args)
+	if err != nil {
+		return nil, err
+	}
+	offset, limit, err := graphQLPage(args)
+	if err != nil {
+		return nil, err
+	}
+	appPages, ok := MongoFindAppPagesGooglePlay(e.m, source.(graphQLApp).packageName, from, to, offset, limit)
+	if !ok {
+		return nil, errors.New("could not read the app pages")
+	}
+
+	return appPages, nil
+}
+
+func resolveAppReviews(e *gqlExecutor, source interface{}, args map[string]interface{}) (interface{}, error) {
+	filter := appReviewFilter{packageName: source.(graphQLApp).packageName, minConfidence: labelConfidenceThreshold}
+	var err error
+	if filter.from, filter.to, err = graphQLDateRange(args); err != nil {
+		return nil, err
+	}
+	if filter.offset, filter.limit, err = graphQLPage(args); err != nil {
+		return nil, err
+	}
+	if value, ok := args["class"]; ok {
+		filter.class = value.(string)
+	}
+	if value, ok := args["min_confidence"]; ok {
+		filter.minConfidence = value.(float64)
+	}
+	if value, ok := args["exclude_duplicates"]; ok {
+		filter.excludeDuplicates = value.(bool)
+	}
+	reviews, ok := MongoFindAppReviewsGooglePlay(e.m, filter)
+	if !ok {
+		return nil, errors.New("could not read the app reviews")
+	}
+
+	return reviews, nil
+}
+
+func resolveAppStatistics(e *gqlExecutor, source interface{}, args map[string]interface{}) (interface{}, error) {
+	statistics, err := MongoGetStatisticsGooglePlay(e.m, source.(graphQLApp).packageName)
+	if err != nil {
+		return nil, errors.New("could not count the stored documents")
+	}
+
+	return statistics, nil
+}
+
+func postGraphQL(w http.ResponseWriter, r *http.Request) {
+	// get data from the request
+	var request GraphQLRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		loggerFrom(r.Context()).Warn("invalid graphql request", "error", err)
+		writeDecodeError(w, r, err)
+		return
+	}
+
+	executeGraphQLRequest(w, r, request)
+}
+
+func getGraphQL(w http.ResponseWriter, r *http.Request) {
+	// get request param
+	request := GraphQLRequest{Query: r.URL.Query().Get("query"), OperationName: r.URL.Query().Get("operationName")}
+	if variables := r.URL.Query().Get("variables"); variables != "" {
+		if err := json.Unmarshal([]byte(variables), &request.Variables); err != nil {
+			loggerFrom(r.Context()).Warn("invalid graphql variables", "error", err)
+			writeError(w, r, http.StatusBadRequest, errorCodeInvalidRequest, "variables must be a JSON object", nil)
+			return
+		}
+	}
+
+	executeGraphQLRequest(w, r, request)
+}
+
+// executeGraphQLRequest answers 400 Bad Request with the errors if the query cannot be executed at all, and 200 OK
+// with the data and the errors of single fields otherwise
+func executeGraphQLRequest(w http.ResponseWriter, r *http.Request, request GraphQLRequest) {
+	if strings.TrimSpace(request.Query) == "" {
+		writeError(w, r, http.StatusBadRequest, errorCodeInvalidRequest, "query is required", nil)
+		return
+	}
+
+	// query db
+	m, release := requestSession(r)
+	defer release()
+	response, err := executeGraphQL(m, graphQLQuery, request)
+
+	// send response
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		loggerFrom(r.Context()).Warn("invalid graphql query", "error", err)
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(GraphQLResponse{Errors: []GraphQLError{{Message: err.Error()}}})
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// getGraphQLSchema describes the GraphQL endpoint in the schema definition language
+func getGraphQLSchema(w http.ResponseWriter, r *http.Request) {
+	// send response
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, graphQLSchemaDefinition(graphQLQuery))
+}
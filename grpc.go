@@ -0,0 +1,385 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// The gRPC service AppStorage of ristorage.proto is served by the router like the HTTP routes, its methods are
+// routes of the form POST /<service>/<method>. The server speaks HTTP/2 without TLS (h2c) on the HTTP port, so that
+// the crawler can stream reviews to the same address.
+
+const (
+	grpcContentType = "application/grpc"
+	grpcService     = "ristorage.v1.AppStorage"
+)
+
+// gRPC status codes
+const (
+	grpcOK                = 0
+	grpcInvalidArgument   = 3
+	grpcNotFound          = 5
+	grpcPermissionDenied  = 7
+	grpcResourceExhausted = 8
+	grpcUnimplemented     = 12
+	grpcInternal          = 13
+	grpcUnavailable       = 14
+	grpcUnauthenticated   = 16
+)
+
+// grpcMethod is a method of the gRPC service
+type grpcMethod struct {
+	name    string
+	handler http.HandlerFunc
+	roles   []string
+	// streamed methods store their messages one by one, the size of each message is limited instead of the body
+	streamed bool
+}
+
+func grpcMethods() []grpcMethod {
+	return []grpcMethod{
+		{name: "StoreAppReviews", handler: grpcStoreAppReviews, roles: []string{roleCrawler}, streamed: true},
+		{name: "StoreAppPage", handler: grpcStoreAppPage, roles: []string{roleCrawler}},
+		{name: "QueryAppReviews", handler: grpcQueryAppReviews, roles: []string{roleAnalyst}},
+		{name: "ListObservables", handler: grpcListObservables, roles: []string{roleCrawler, roleAnalyst}},
+	}
+}
+
+// grpcMethodRoles and grpcStreamedRoutes list the roles and the streamed methods by route
+var grpcMethodRoles, grpcStreamedRoutes = grpcRoutes(grpcMethods())
+
+// grpcRoutes returns the roles of the methods and the streamed methods by route, the streamed methods are exempt from
+// the body size limit
+func grpcRoutes(methods []grpcMethod) (roles map[string][]string, streamed map[string]bool) {
+	roles, streamed = map[string][]string{}, map[string]bool{}
+	for _, method := range methods {
+		route := "POST " + grpcPath(method.name)
+		roles[route] = method.roles
+		streamed[route] = method.streamed
+	}
+
+	return roles, streamed
+}
+
+func grpcPath(method string) string {
+	return "/" + grpcService + "/" + method
+}
+
+// isGRPCRequest returns true if the request is a gRPC call, which is answered with a gRPC status instead of the
+// response envelope
+func isGRPCRequest(r *http.Request) bool {
+	contentType := r.Header.Get("Content-Type")
+	return contentType == grpcContentType || strings.HasPrefix(contentType, grpcContentType+"+proto")
+}
+
+// grpcStatusOf maps the error codes of the response envelope to gRPC status codes
+func grpcStatusOf(code string) int {
+	switch code {
+	case errorCodeInvalidRequest, errorCodeValidation:
+		return grpcInvalidArgument
+	case errorCodePayloadTooLarge, errorCodeRateLimited:
+		return grpcResourceExhausted
+	case errorCodeUnauthorized:
+		return grpcUnauthenticated
+	case errorCodeForbidden:
+		return grpcPermissionDenied
+	case errorCodeNotFound:
+		return grpcNotFound
+	case errorCodeMethodNotAllowed:
+		return grpcUnimplemented
+	case errorCodeUnavailable:
+		return grpcUnavailable
+	default:
+		return grpcInternal
+	}
+}
+
+// writeGRPCStatus ends a gRPC call with the status in the trailers
+func writeGRPCStatus(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", grpcContentType)
+	w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(status))
+	if message != "" {
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", encodeGRPCMessage(message))
+	}
+}
+
+// encodeGRPCMessage percent-encodes the status message as the gRPC protocol requires
+func encodeGRPCMessage(message string) string {
+	var b strings.Builder
+	for i := 0; i < len(message); i++ {
+		c := message[i]
+		if c < ' ' || c > '~' || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+
+	return b.String()
+}
+
+var (
+	errGRPCCompressed     = errors.New("compressed messages are not supported")
+	errGRPCMissingMessage = errors.New("the request carries no message")
+)
+
+// grpcStream reads the length-prefixed messages of a gRPC request and writes those of the response
+type grpcStream struct {
+	w       http.ResponseWriter
+	r       *http.Request
+	started bool
+}
+
+// receive decodes the next message of the request into v, it returns io.EOF after the last message
+func (s *grpcStream) receive(v interface{}) error {
+	var prefix [5]byte
+	if _, err := io.ReadFull(s.r.Body, prefix[:]); err != nil {
+		return err
+	}
+	if prefix[0] != 0 {
+		return errGRPCCompressed
+	}
+	length := binary.BigEndian.Uint32(prefix[1:])
+	if int64(length) > config.Limits.MaxBodyBytes {
+		return &http.MaxBytesError{Limit: config.Limits.MaxBodyBytes}
+	}
+	message := make([]byte, length)
+	if _, err := io.ReadFull(s.r.Body, message); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	return unmarshalProto(message, v)
+}
+
+// receiveOne decodes the single message of a unary or server-streaming call into v
+func (s *grpcStream) receiveOne(v interface{}) error {
+	if err := s.receive(v); err != io.EOF {
+		return err
+	}
+
+	return errGRPCMissingMessage
+}
+
+// send writes a message of the response and flushes it to the client
+func (s *grpcStream) send(v interface{}) error {
+	if !s.started {
+		s.w.Header().Set("Content-Type", grpcContentType)
+		s.w.WriteHeader(http.StatusOK)
+		s.started = true
+	}
+	message := marshalProto(v)
+	frame := make([]byte, 5, 5+len(message))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(message)))
+	if _, err := s.w.Write(append(frame, message...)); err != nil {
+		return err
+	}
+	if f, ok := s.w.(http.Flusher); ok {
+		f.Flush()
+	}
+
+	return nil
+}
+
+// finish ends a successful call
+func (s *grpcStream) finish() {
+	writeGRPCStatus(s.w, grpcOK, "")
+}
+
+// writeReceiveError ends a gRPC call whose request message could not be received
+func writeReceiveError(w http.ResponseWriter, r *http.Request, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		rejectedRequests.add(1, "body_too_large")
+		writeError(w, r, http.StatusRequestEntityTooLarge, errorCodePayloadTooLarge,
+			fmt.Sprintf("a message may have at most %d bytes", tooLarge.Limit), nil)
+		return
+	}
+
+	writeError(w, r, http.StatusBadRequest, errorCodeInvalidRequest, "invalid message: "+err.Error(), nil)
+}
+
+// grpcStoreAppReviews is the client-streaming method StoreAppReviews. Invalid reviews are rejected one by one like the
+// lines of an import, the others are stored in batches.
+func grpcStoreAppReviews(w http.ResponseWriter, r *http.Request) {
+	stream := &grpcStream{w: w, r: r}
+	m, release := requestSession(r)
+	defer release()
+	tenant := tenantFrom(r.Context())
+
+	summary := ImportSummary{RejectedLines: []ImportRejection{}}
+	var batch []AppReviewGooglePlay
+	// the stream is audited once when it ends
+	keys := &auditKeys{}
+	store := func() bool {
+		inserted, updated, ok := storeAppReviews(m, tenant, batch)
+		summary.Inserted += inserted
+		summary.Updated += updated
+		for _, review := range batch {
+			keys.addAppReview(review)
+		}
+		batch = batch[:0]
+		return ok
+	}
+	auditStream := func(ok bool) {
+		audit(r, m, keys, AuditEntryGooglePlay{
+			Action: auditStoreAppReviews,
+			Counts: map[string]int{"received": summary.Read, "inserted": summary.Inserted, "updated": summary.Updated, "rejected": summary.Rejected},
+			Failed: !ok,
+		})
+	}
+
+	for {
+		// get data from the request
+		var review AppReviewGooglePlay
+		err := stream.receive(&review)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			loggerFrom(r.Context()).Warn("invalid gRPC message", "method", "StoreAppReviews", "error", err)
+			if summary.Inserted+summary.Updated > 0 {
+				auditStream(false)
+			}
+			writeReceiveError(w, r, err)
+			return
+		}
+		summary.Read++
+		if errs := validateAppReview(review, ""); len(errs) > 0 {
+			summary.reject(summary.Read, errs)
+			continue
+		}
+
+		// insert data into the db
+		batch = append(batch, review)
+		if len(batch) >= importBatchSize && !store() {
+			auditStream(false)
+			writeStorageError(w, r, "could not store the app reviews")
+			return
+		}
+	}
+	if !store() {
+		auditStream(false)
+		writeStorageError(w, r, "could not store the app reviews")
+		return
+	}
+	auditStream(true)
+
+	// send response
+	stream.send(summary)
+	stream.finish()
+}
+
+// grpcStoreAppPage is the unary method StoreAppPage
+func grpcStoreAppPage(w http.ResponseWriter, r *http.Request) {
+	// get data from the request
+	stream := &grpcStream{w: w, r: r}
+	var appPage AppPageGooglePlay
+	if err := stream.receiveOne(&appPage); err != nil {
+		loggerFrom(r.Context()).Warn("invalid gRPC message", "method", "StoreAppPage", "error", err)
+		writeReceiveError(w, r, err)
+		return
+	}
+	if errs := validateAppPage(appPage); len(errs) > 0 {
+		writeValidationErrors(w, r, errs)
+		return
+	}
+
+	// insert data into the db
+	m, release := requestSession(r)
+	defer release()
+	isNew, ok := MongoInsertAppPageGooglePlay(m, appPage)
+	auditAppPage(r, m, appPage, isNew, ok)
+	if !ok {
+		writeStorageError(w, r, "could not store the app page")
+		return
+	}
+	summary := ImportSummary{Read: 1, Existing: 1, RejectedLines: []ImportRejection{}}
+	if isNew {
+		notifyAppPageSubscribers(m, appPage)
+		summary.Inserted, summary.Existing = 1, 0
+	}
+
+	// send response
+	stream.send(summary)
+	stream.finish()
+}
+
+// grpcQueryAppReviews is the server-streaming method QueryAppReviews, it sends the matching reviews newest first
+func grpcQueryAppReviews(w http.ResponseWriter, r *http.Request) {
+	// get data from the request
+	stream := &grpcStream{w: w, r: r}
+	var query AppReviewQuery
+	if err := stream.receiveOne(&query); err != nil {
+		loggerFrom(r.Context()).Warn("invalid gRPC message", "method", "QueryAppReviews", "error", err)
+		writeReceiveError(w, r, err)
+		return
+	}
+	v := validator{}
+	v.packageName(query.PackageName, "package_name", true)
+	v.check(query.MinConfidence >= 0 && query.MinConfidence <= 1, "min_confidence", "must be between 0 and 1")
+	v.dateBound(query.From, "from")
+	v.dateBound(query.To, "to")
+	v.check(query.Limit >= 0, "limit", "must not be negative")
+	if len(v.errs) > 0 {
+		writeValidationErrors(w, r, v.errs)
+		return
+	}
+	filter := appReviewFilter{
+		packageName:       query.PackageName,
+		class:             query.Class,
+		minConfidence:     query.MinConfidence,
+		excludeDuplicates: query.ExcludeDuplicates,
+		from:              query.From,
+		to:                query.To,
+		limit:             query.Limit,
+	}
+	if filter.minConfidence == 0 {
+		filter.minConfidence = labelConfidenceThreshold
+	}
+
+	// query db and send response
+	m, release := requestSession(r)
+	defer release()
+	var sendErr error
+	err := MongoForEachMatchingAppReviewGooglePlay(m, filter, func(review AppReviewGooglePlay) error {
+		sendErr = stream.send(review)
+		return sendErr
+	})
+	if sendErr != nil {
+		loggerFrom(r.Context()).Warn("gRPC stream aborted", "method", "QueryAppReviews", "error", sendErr)
+		return
+	}
+	if err != nil {
+		writeStorageError(w, r, "could not read the app reviews")
+		return
+	}
+	stream.finish()
+}
+
+// grpcListObservables is the unary method ListObservables
+func grpcListObservables(w http.ResponseWriter, r *http.Request) {
+	// get data from the request
+	stream := &grpcStream{w: w, r: r}
+	if err := stream.receiveOne(&Empty{}); err != nil {
+		loggerFrom(r.Context()).Warn("invalid gRPC message", "method", "ListObservables", "error", err)
+		writeReceiveError(w, r, err)
+		return
+	}
+
+	// query db
+	m, release := requestSession(r)
+	defer release()
+	observables := MongoGetAllObservableGooglePlay(m)
+
+	// send response
+	stream.send(ObservableList{Observables: observables})
+	stream.finish()
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"runtime"
+	"strings"
+	"time"
+
+	mgo "gopkg.in/mgo.v2"
+)
+
+const (
+	healthStatusOK      = "ok"
+	healthStatusFailing = "failing"
+
+	healthCheckTimeout = 2 * time.Second
+)
+
+// version is the build version, it is set with go install -ldflags "-X main.version=<version>"
+var version = "dev"
+
+var startedAt = time.Now()
+
+// healthStatus reports the build and the given checks, it is failing if any check is failing
+func healthStatus(checks ...HealthCheck) HealthStatus {
+	status := HealthStatus{
+		Status:        healthStatusOK,
+		Version:       version,
+		GoVersion:     runtime.Version(),
+		UptimeSeconds: int64(time.Since(startedAt).Seconds()),
+		Checks:        checks,
+	}
+	for _, check := range checks {
+		if check.Status != healthStatusOK {
+			status.Status = healthStatusFailing
+		}
+	}
+
+	return status
+}
+
+func newHealthCheck(name string, err error) HealthCheck {
+	if err != nil {
+		return HealthCheck{Name: name, Status: healthStatusFailing, Error: err.Error()}
+	}
+
+	return HealthCheck{Name: name, Status: healthStatusOK}
+}
+
+// checkReadiness reports whether the service finished its startup and is connected to the database
+func checkReadiness() HealthCheck {
+	ready, reason := readiness.get()
+	if !ready {
+		return newHealthCheck("readiness", errors.New(reason))
+	}
+
+	return newHealthCheck("readiness", nil)
+}
+
+// checkDatabase pings the database and gives up after healthCheckTimeout
+func checkDatabase(mongoClient *mgo.Session) HealthCheck {
+	if mongoClient == nil {
+		return newHealthCheck("database", errors.New("not connected"))
+	}
+
+	m := mongoClient.Copy()
+	result := make(chan error, 1)
+	go func() {
+		defer m.Close()
+		result <- m.Ping()
+	}()
+
+	select {
+	case err := <-result:
+		return newHealthCheck("database", err)
+	case <-time.After(healthCheckTimeout):
+		return newHealthCheck("database", errors.New("ping timed out after "+healthCheckTimeout.String()))
+	}
+}
+
+// checkIndexes reports the indexes that have not been created yet
+func checkIndexes(mongoClient *mgo.Session) HealthCheck {
+	if mongoClient == nil {
+		return newHealthCheck("indexes", errors.New("not connected"))
+	}
+
+	var missing []string
+	var err error
+	forEachTenant(mongoClient, func(m *storageSession) {
+		tenantMissing, tenantErr := MongoGetMissingIndexes(m)
+		if tenantErr != nil {
+			err = tenantErr
+		}
+		missing = append(missing, tenantMissing...)
+	})
+	if err != nil {
+		return newHealthCheck("indexes", err)
+	}
+	if len(missing) > 0 {
+		check := newHealthCheck("indexes", errors.New("missing "+strings.Join(missing, "; ")))
+		check.MissingIndexes = missing
+		return check
+	}
+
+	return newHealthCheck("indexes", nil)
+}
@@ -0,0 +1,403 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	importBatchSize = 500
+
+	// maxReportedRejections limits the rejected lines listed in an import summary, all are counted
+	maxReportedRejections = 1000
+)
+
+// errImportStore is returned when a batch of valid records could not be stored
+var errImportStore = errors.New("could not store the imported records")
+
+// importRecord is one line of an import file, either a json object or a csv row keyed by the header.
+// err is set if the line could not be parsed.
+type importRecord struct {
+	line    int
+	json    []byte
+	columns map[string]string
+	err     error
+}
+
+// forEachImportRecord reads the import file record by record and calls f for each of them.
+// It stops with an error if the file itself cannot be read, invalid records are up to f.
+func forEachImportRecord(in io.Reader, format string, f func(importRecord) error) error {
+	switch format {
+	case exportFormatJSONLines:
+		reader := bufio.NewReader(in)
+		for line := 1; ; line++ {
+			data, err := reader.ReadBytes('\n')
+			if len(bytes.TrimSpace(data)) > 0 {
+				if ferr := f(importRecord{line: line, json: data}); ferr != nil {
+					return ferr
+				}
+			}
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+	case exportFormatCSV:
+		reader := csv.NewReader(in)
+		reader.FieldsPerRecord = -1
+		header, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		for {
+			row, err := reader.Read()
+			if err == io.EOF {
+				return nil
+			}
+			if parseErr, ok := err.(*csv.ParseError); ok {
+				if err = f(importRecord{line: parseErr.Line, err: parseErr}); err != nil {
+					return err
+				}
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			columns := make(map[string]string, len(header))
+			for i, name := range header {
+				if i < len(row) {
+					columns[name] = row[i]
+				}
+			}
+			line, _ := reader.FieldPos(0)
+			if err = f(importRecord{line: line, columns: columns}); err != nil {
+				return err
+			}
+		}
+	}
+
+	return fmt.Errorf("unknown import format %q", format)
+}
+
+// decodeImportJSON decodes a json record and rejects fields the model does not know
+func decodeImportJSON(data []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	return decoder.Decode(v)
+}
+
+// reject counts an invalid record and remembers why it was rejected
+func (summary *ImportSummary) reject(line int, err error) {
+	summary.Rejected++
+	if len(summary.RejectedLines) < maxReportedRejections {
+		summary.RejectedLines = append(summary.RejectedLines, ImportRejection{Line: line, Error: err.Error()})
+	}
+}
+
+// importAppReviews validates the reviews of an import file and upserts them in batches with their authors protected
+// as configured. A review repeated within a batch updates the earlier one. The stored reviews are announced like
+// posted ones and their keys are collected in keys, which may be nil.
+func importAppReviews(mongoClient *storageSession, in io.Reader, format string, keys *auditKeys) (ImportSummary, error) {
+	summary := ImportSummary{RejectedLines: []ImportRejection{}}
+	tenant := tenantFrom(mongoClient.ctx)
+	var batch []AppReviewGooglePlay
+	positions := map[string]int{}
+	store := func() error {
+		stored, newReviews, ok := MongoBulkUpsertAppReviewGooglePlay(mongoClient, batch)
+		if !ok {
+			return errImportStore
+		}
+		summary.Inserted += len(newReviews)
+		summary.Updated += len(stored) - len(newReviews)
+		for _, review := range stored {
+			keys.addAppReview(review)
+		}
+		announceAppReviews(mongoClient, tenant, stored, newReviews)
+		batch = batch[:0]
+		positions = map[string]int{}
+		return nil
+	}
+
+	err := forEachImportRecord(in, format, func(record importRecord) error {
+		summary.Read++
+		review, err := decodeImportedAppReview(record)
+		if err != nil {
+			summary.reject(record.line, err)
+			return nil
+		}
+
+		review = protectAuthor(review)
+		if i, ok := positions[review.ReviewID]; ok {
+			batch[i] = review
+			summary.Updated++
+			return nil
+		}
+		positions[review.ReviewID] = len(batch)
+		batch = append(batch, review)
+		if len(batch) < importBatchSize {
+			return nil
+		}
+		return store()
+	})
+	if err != nil {
+		return summary, err
+	}
+
+	return summary, store()
+}
+
+// importAppPages validates the app pages of an import file and inserts them in batches. An app page repeated within a
+// batch exists already. The new pages are delivered to the subscriptions and the package names of the stored pages are
+// collected in keys, which may be nil.
+func importAppPages(mongoClient *storageSession, in io.Reader, format string, keys *auditKeys) (ImportSummary, error) {
+	summary := ImportSummary{RejectedLines: []ImportRejection{}}
+	var batch []AppPageGooglePlay
+	batched := map[string]bool{}
+	store := func() error {
+		newAppPages, existing, ok := MongoBulkInsertAppPageGooglePlay(mongoClient, batch)
+		if !ok {
+			return errImportStore
+		}
+		summary.Inserted += len(newAppPages)
+		summary.Existing += existing
+		for _, appPage := range batch {
+			keys.add(appPage.PackageName)
+		}
+		notifyAppPageSubscribers(mongoClient, newAppPages...)
+		batch = batch[:0]
+		batched = map[string]bool{}
+		return nil
+	}
+
+	err := forEachImportRecord(in, format, func(record importRecord) error {
+		summary.Read++
+		appPage, err := decodeImportedAppPage(record)
+		if err != nil {
+			summary.reject(record.line, err)
+			return nil
+		}
+
+		key := fmt.Sprintf("%s/%d", appPage.PackageName, appPage.LastUpdate)
+		if batched[key] {
+			summary.Existing++
+			return nil
+		}
+		batched[key] = true
+		batch = append(batch, appPage)
+		if len(batch) < importBatchSize {
+			return nil
+		}
+		return store()
+	})
+	if err != nil {
+		return summary, err
+	}
+
+	return summary, store()
+}
+
+// decodeImportedAppReview turns an import record into a valid review
+func decodeImportedAppReview(record importRecord) (AppReviewGooglePlay, error) {
+	var review AppReviewGooglePlay
+	var err error
+	switch {
+	case record.err != nil:
+		return review, record.err
+	case record.json != nil:
+		err = decodeImportJSON(record.json, &review)
+	default:
+		review, err = appReviewFromCSV(record.columns)
+	}
+	if err != nil {
+		return review, err
+	}
+
+	return review, validateImportedAppReview(review)
+}
+
+// decodeImportedAppPage turns an import record into a valid app page
+func decodeImportedAppPage(record importRecord) (AppPageGooglePlay, error) {
+	var appPage AppPageGooglePlay
+	var err error
+	switch {
+	case record.err != nil:
+		return appPage, record.err
+	case record.json != nil:
+		err = decodeImportJSON(record.json, &appPage)
+	default:
+		appPage, err = appPageFromCSV(record.columns)
+	}
+	if err != nil {
+		return appPage, err
+	}
+
+	return appPage, validateImportedAppPage(appPage)
+}
+
+func validateImportedAppReview(review AppReviewGooglePlay) error {
+	if errs := validateAppReview(review, ""); len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+func validateImportedAppPage(appPage AppPageGooglePlay) error {
+	if errs := validateAppPage(appPage); len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+// csvColumns parses the typed columns of a csv record and remembers the first error
+type csvColumns struct {
+	values map[string]string
+	err    error
+}
+
+func (c *csvColumns) string(name string) string {
+	return c.values[name]
+}
+
+func (c *csvColumns) int64(name string) int64 {
+	value := c.values[name]
+	if value == "" || c.err != nil {
+		return 0
+	}
+	v, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		c.err = fmt.Errorf("%s: %q is not an integer", name, value)
+	}
+
+	return v
+}
+
+func (c *csvColumns) int(name string) int {
+	return int(c.int64(name))
+}
+
+func (c *csvColumns) float(name string) float64 {
+	value := c.values[name]
+	if value == "" || c.err != nil {
+		return 0
+	}
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		c.err = fmt.Errorf("%s: %q is not a number", name, value)
+	}
+
+	return v
+}
+
+func (c *csvColumns) bool(name string) bool {
+	value := c.values[name]
+	if value == "" || c.err != nil {
+		return false
+	}
+	v, err := strconv.ParseBool(value)
+	if err != nil {
+		c.err = fmt.Errorf("%s: %q is not a boolean", name, value)
+	}
+
+	return v
+}
+
+func (c *csvColumns) list(name, separator string) []string {
+	value := c.values[name]
+	if value == "" {
+		return nil
+	}
+
+	return strings.Split(value, separator)
+}
+
+func (c *csvColumns) labels(name string) []ReviewLabel {
+	var labels []ReviewLabel
+	for _, entry := range c.list(name, ";") {
+		parts := strings.SplitN(entry, ":", 2)
+		label := ReviewLabel{Name: parts[0], Confidence: 1}
+		if len(parts) == 2 {
+			confidence, err := strconv.ParseFloat(parts[1], 64)
+			if err != nil && c.err == nil {
+				c.err = fmt.Errorf("%s: %q is not a label with confidence", name, entry)
+			}
+			label.Confidence = confidence
+		}
+		labels = append(labels, label)
+	}
+
+	return labels
+}
+
+// appReviewFromCSV reads a review from a csv record using the columns of the review export
+func appReviewFromCSV(values map[string]string) (AppReviewGooglePlay, error) {
+	c := &csvColumns{values: values}
+	review := AppReviewGooglePlay{
+		ReviewID:       c.string("review_id"),
+		PackageName:    c.string("package_name"),
+		Author:         c.string("author"),
+		Date:           c.int64("date_posted"),
+		Rating:         c.int("rating"),
+		Title:          c.string("title"),
+		Body:           c.string("body"),
+		PermaLink:      c.string("perma_link"),
+		FeatureRequest: c.bool("cluster_is_feature_request"),
+		BugReport:      c.bool("cluster_is_bug_report"),
+		Labels:         c.labels("labels"),
+	}
+
+	return review, c.err
+}
+
+// appPageFromCSV reads an app page from a csv record using the columns of the app page export
+func appPageFromCSV(values map[string]string) (AppPageGooglePlay, error) {
+	c := &csvColumns{values: values}
+	appPage := AppPageGooglePlay{
+		Name:          c.string("name"),
+		PackageName:   c.string("package_name"),
+		DateCrawled:   c.int64("date_crawled"),
+		Category:      c.string("category"),
+		USK:           c.string("usk"),
+		Price:         c.string("price"),
+		PriceValue:    c.float("price_value"),
+		PriceCurrency: c.string("price_currency"),
+		Description:   c.string("description"),
+		WhatsNew:      c.list("whats_new", "\n"),
+		Rating:        c.float("rating"),
+		StarsCount:    c.int64("stars_count"),
+		CountPerRating: StarCountPerRating{
+			Five:  c.int("count_per_rating_5"),
+			Four:  c.int("count_per_rating_4"),
+			Three: c.int("count_per_rating_3"),
+			Two:   c.int("count_per_rating_2"),
+			One:   c.int("count_per_rating_1"),
+		},
+		EstimatedDownloadNumber: c.int64("estimated_download_number"),
+		DeveloperName:           c.string("developer"),
+		TopDeveloper:            c.bool("top_developer"),
+		ContainsAds:             c.bool("contains_ads"),
+		InAppPurchases:          c.bool("in_app_purchase"),
+		LastUpdate:              c.int64("last_update"),
+		Os:                      c.string("os"),
+		RequiresOsVersion:       c.string("requires_os_version"),
+		CurrentSoftwareVersion:  c.string("current_software_version"),
+		SimilarApps:             c.list("similar_apps", ";"),
+	}
+
+	return appPage, c.err
+}
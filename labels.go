@@ -0,0 +1,78 @@
+package main
+
+import (
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	reviewClassBugReport      = "bug_report"
+	reviewClassFeatureRequest = "feature_request"
+
+	// labelSourceLegacy marks labels that were derived from the boolean class fields
+	labelSourceLegacy = "legacy"
+
+	// labelConfidenceThreshold is the confidence a label needs to set the boolean class field
+	labelConfidenceThreshold = 0.5
+)
+
+// legacyClassFields maps a review class to the boolean field used before labels existed
+var legacyClassFields = map[string]string{
+	reviewClassBugReport:      "cluster_is_bug_report",
+	reviewClassFeatureRequest: "cluster_is_feature_request",
+}
+
+// labelConfidence returns the confidence of the label with the given name and whether the review has it
+func labelConfidence(review AppReviewGooglePlay, name string) (float64, bool) {
+	for _, label := range review.Labels {
+		if label.Name == name {
+			return label.Confidence, true
+		}
+	}
+
+	return 0, false
+}
+
+// syncReviewLabels keeps the labels and the boolean class fields of a review consistent.
+// A label overrides its boolean field; a set boolean field without a label becomes a legacy label.
+func syncReviewLabels(review AppReviewGooglePlay) AppReviewGooglePlay {
+	classFlags := []struct {
+		class string
+		flag  *bool
+	}{
+		{reviewClassBugReport, &review.BugReport},
+		{reviewClassFeatureRequest, &review.FeatureRequest},
+	}
+
+	for _, c := range classFlags {
+		confidence, ok := labelConfidence(review, c.class)
+		if ok {
+			*c.flag = confidence >= labelConfidenceThreshold
+		} else if *c.flag {
+			review.Labels = append(review.Labels, ReviewLabel{Name: c.class, Confidence: 1, Source: labelSourceLegacy})
+		}
+	}
+
+	return review
+}
+
+// reviewClassQuery returns the query selecting the reviews of a package that carry the given class
+// with at least the given confidence. Reviews stored without labels count as fully confident.
+func reviewClassQuery(packageName, reviewClass string, minConfidence float64) bson.M {
+	labelMatch := bson.M{"labels": bson.M{"$elemMatch": bson.M{
+		"name":       reviewClass,
+		"confidence": bson.M{"$gte": minConfidence},
+	}}}
+
+	legacyField, ok := legacyClassFields[reviewClass]
+	if !ok || minConfidence > 1 {
+		return bson.M{"package_name": packageName, "labels": labelMatch["labels"]}
+	}
+
+	return bson.M{
+		"package_name": packageName,
+		"$or": []bson.M{
+			labelMatch,
+			{legacyField: true, "labels": bson.M{"$exists": false}},
+		},
+	}
+}
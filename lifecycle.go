@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	mgo "gopkg.in/mgo.v2"
+)
+
+const (
+	mongoRetryMinInterval = time.Second
+	mongoRetryMaxInterval = 30 * time.Second
+
+	// mongoWatchdogInterval is how often the database connection is checked while serving
+	mongoWatchdogInterval = 5 * time.Second
+
+	// notReadyRetryAfter is the number of seconds clients are asked to wait while the service is not ready
+	notReadyRetryAfter = "5"
+)
+
+// shuttingDown is closed when the graceful shutdown begins, long running handlers and background jobs stop then
+var shuttingDown = make(chan struct{})
+
+// requestsDrained is closed when the server answered its last request, the jobs finishing the work of requests like
+// the webhook deliveries stop then
+var requestsDrained = make(chan struct{})
+
+// readinessState tracks whether the service can handle requests and why not
+type readinessState struct {
+	mu     sync.Mutex
+	ready  bool
+	reason string
+}
+
+var readiness = &readinessState{reason: "not connected to the database yet"}
+
+// mongoClientMu guards mongoClient while it is set in the background, the probes read it before the service is ready
+var mongoClientMu sync.RWMutex
+
+func setMongoClient(session *mgo.Session) {
+	mongoClientMu.Lock()
+	defer mongoClientMu.Unlock()
+	mongoClient = session
+}
+
+func currentMongoClient() *mgo.Session {
+	mongoClientMu.RLock()
+	defer mongoClientMu.RUnlock()
+	return mongoClient
+}
+
+func (s *readinessState) set(ready bool, reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ready, s.reason = ready, reason
+}
+
+func (s *readinessState) get() (bool, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ready, s.reason
+}
+
+// probePaths are called by orchestrators without authentication and rate limit
+var probePaths = map[string]bool{
+	"/healthz": true,
+	"/readyz":  true,
+}
+
+// unreadyPaths are answered even if the service is not ready, the probes, the metrics and the description of the API
+var unreadyPaths = map[string]bool{
+	"/healthz":         true,
+	"/readyz":          true,
+	"/metrics":         true,
+	"/v2/openapi.json": true,
+}
+
+// requireReady answers 503 Service Unavailable while the service is not ready, e.g. before the database is reachable
+func requireReady(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ready, reason := readiness.get(); !ready && !unreadyPaths[r.URL.Path] {
+			slog.Warn("rejected request, service not ready", "method", r.Method, "path", r.URL.Path, "reason", reason)
+			w.Header().Set("Retry-After", notReadyRetryAfter)
+			writeError(w, r, http.StatusServiceUnavailable, errorCodeUnavailable, "service not ready: "+reason, nil)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// mongoConnectWithRetry dials the database until it is reachable, waiting exponentially longer between the attempts.
+// It returns nil if stop is closed before a connection was established.
+func mongoConnectWithRetry(mongoConfig MongoConfig, stop <-chan struct{}) *mgo.Session {
+	wait := mongoRetryMinInterval
+	for {
+		session, err := MongoGetSession(mongoConfig)
+		if err == nil {
+			return session
+		}
+		slog.Error("database not reachable", "retry_in", wait.String(), "error", err)
+		readiness.set(false, "database not reachable: "+err.Error())
+
+		select {
+		case <-stop:
+			return nil
+		case <-time.After(wait):
+		}
+		wait *= 2
+		if wait > mongoRetryMaxInterval {
+			wait = mongoRetryMaxInterval
+		}
+	}
+}
+
+// runMongoWatchdog pings the database periodically. If the connection dropped, the service is marked as not ready
+// and the session is refreshed, so that it reconnects as soon as the database is reachable again.
+func runMongoWatchdog(mongoClient *mgo.Session, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		m := mongoClient.Copy()
+		err := m.Ping()
+		m.Close()
+		if err == nil {
+			if ready, _ := readiness.get(); !ready {
+				slog.Info("database reachable again")
+				readiness.set(true, "")
+			}
+			continue
+		}
+		slog.Error("lost the database connection", "error", err)
+		readiness.set(false, "lost the database connection: "+err.Error())
+		mongoClient.Refresh()
+	}
+}
+
+// serveUntilSignal serves the http api and connects to the database in the background. On SIGTERM or SIGINT it stops
+// accepting requests, drains the in-flight requests and stops the background jobs before it closes the sessions.
+func serveUntilSignal(server *http.Server, shutdownTimeout time.Duration, start func(session *mgo.Session, jobs *sync.WaitGroup)) error {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	defer signal.Stop(signals)
+
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.ListenAndServe()
+	}()
+
+	var jobs sync.WaitGroup
+	jobs.Add(1)
+	go func() {
+		defer jobs.Done()
+		session := mongoConnectWithRetry(config.Mongo, shuttingDown)
+		if session == nil {
+			return
+		}
+		setMongoClient(session)
+		start(session, &jobs)
+		select {
+		case <-shuttingDown:
+		default:
+			readiness.set(true, "")
+			slog.Info("database connected, service is ready")
+		}
+	}()
+
+	select {
+	case err := <-serverErr:
+		close(shuttingDown)
+		close(requestsDrained)
+		jobs.Wait()
+		return err
+	case sig := <-signals:
+		slog.Info("shutting down", "signal", sig.String())
+	}
+
+	readiness.set(false, "shutting down")
+	close(shuttingDown)
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	err := server.Shutdown(ctx)
+	close(requestsDrained)
+	jobs.Wait()
+	if mongoClient != nil {
+		mongoClient.Close()
+	}
+
+	return err
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMaxBodyBytes   = 16 << 20
+	defaultMaxArrayLength = 10000
+
+	// maxRateLimitClients is the number of tracked clients above which idle clients are forgotten
+	maxRateLimitClients = 10000
+)
+
+// bodyLimitExemptRoutes stream their bodies into the database, so that their size is not limited
+var bodyLimitExemptRoutes = map[string]bool{
+	"POST /hitec/repository/app/import/app-review/google-play": true,
+	"POST /hitec/repository/app/import/app-page/google-play":   true,
+}
+
+// isBodyLimitExempt returns true if the route streams its body into the database
+func isBodyLimitExempt(route string) bool {
+	return bodyLimitExemptRoutes[route] || grpcStreamedRoutes[route]
+}
+
+// tokenBucket allows burst requests at once and refills at rate requests per second
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter keeps a token bucket per client
+type rateLimiter struct {
+	rate  float64
+	burst float64
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	return &rateLimiter{rate: rate, burst: float64(burst), buckets: map[string]*tokenBucket{}}
+}
+
+// allow takes a token of the client and returns the time to wait for the next token if there is none
+func (l *rateLimiter) allow(client string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	bucket, ok := l.buckets[client]
+	if !ok {
+		if len(l.buckets) >= maxRateLimitClients {
+			l.forgetIdleClients(now)
+		}
+		bucket = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[client] = bucket
+	}
+	bucket.tokens = math.Min(l.burst, bucket.tokens+now.Sub(bucket.last).Seconds()*l.rate)
+	bucket.last = now
+	if bucket.tokens < 1 {
+		return false, time.Duration((1 - bucket.tokens) / l.rate * float64(time.Second))
+	}
+	bucket.tokens--
+
+	return true, 0
+}
+
+// forgetIdleClients removes the buckets that are full again, their clients start with a full bucket anyway
+func (l *rateLimiter) forgetIdleClients(now time.Time) {
+	for client, bucket := range l.buckets {
+		if bucket.tokens+now.Sub(bucket.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+// rateLimit is nil if rate limiting is disabled
+var rateLimit *rateLimiter
+
+// clientKey identifies the client of a request, by its name if it is authenticated and by its address otherwise
+func clientKey(r *http.Request) string {
+	if p, ok := principalFrom(r.Context()); ok {
+		return p.tenant + "/" + p.name
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// limitRequests answers 429 Too Many Requests to clients exceeding their rate limit and limits the size of the
+// request bodies, handlers answer 413 Request Entity Too Large if the body exceeds it. It runs before the clients are
+// authenticated, so that failing authentications are limited as well, the clients are identified by their address.
+func limitRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if probePaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if limiter := rateLimit; limiter != nil {
+			if ok, wait := limiter.allow(clientKey(r), time.Now()); !ok {
+				loggerFrom(r.Context()).Warn("rate limit exceeded", "client", clientKey(r), "path", r.URL.Path)
+				rejectedRequests.add(1, "rate_limit")
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				writeError(w, r, http.StatusTooManyRequests, errorCodeRateLimited, "rate limit exceeded", nil)
+				return
+			}
+		}
+
+		if r.Body != nil && !isBodyLimitExempt(r.Method+" "+routeTemplate(r)) {
+			r.Body = http.MaxBytesReader(w, r.Body, config.Limits.MaxBodyBytes)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// writeDecodeError answers 413 Request Entity Too Large if the body could not be decoded because it exceeded the
+// size limit and 400 Bad Request otherwise
+func writeDecodeError(w http.ResponseWriter, r *http.Request, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		rejectedRequests.add(1, "body_too_large")
+		writeError(w, r, http.StatusRequestEntityTooLarge, errorCodePayloadTooLarge,
+			fmt.Sprintf("the body exceeds %d bytes", tooLarge.Limit), nil)
+		return
+	}
+
+	writeError(w, r, http.StatusBadRequest, errorCodeInvalidRequest, "the body is not valid JSON: "+err.Error(), nil)
+}
+
+// writeArrayTooLong answers 413 Request Entity Too Large to requests with more items than allowed
+func writeArrayTooLong(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, http.StatusRequestEntityTooLarge, errorCodePayloadTooLarge,
+		fmt.Sprintf("a request may carry at most %d items", config.Limits.MaxArrayLength), nil)
+}
+
+// exceedsMaxArrayLength returns true and counts the rejection if a request carries more items than allowed
+func exceedsMaxArrayLength(length int) bool {
+	if length <= config.Limits.MaxArrayLength {
+		return false
+	}
+	rejectedRequests.add(1, "array_too_long")
+
+	return true
+}
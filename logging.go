@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	mgo "gopkg.in/mgo.v2"
+)
+
+const (
+	logFormatJSON = "json"
+	logFormatText = "text"
+
+	requestIDHeader = "X-Request-ID"
+
+	// maxRequestIDLength limits request ids passed in by clients, longer ones are replaced
+	maxRequestIDLength = 64
+)
+
+var logLevels = map[string]slog.Level{
+	"debug": slog.LevelDebug,
+	"info":  slog.LevelInfo,
+	"warn":  slog.LevelWarn,
+	"error": slog.LevelError,
+}
+
+type contextKey int
+
+const (
+	requestIDKey contextKey = iota
+	spanKey
+	principalKey
+	tenantKey
+)
+
+// setupLogging makes the configured logger the default logger
+func setupLogging(out io.Writer, level, format string) {
+	options := &slog.HandlerOptions{Level: logLevels[level]}
+	var handler slog.Handler = slog.NewJSONHandler(out, options)
+	if format == logFormatText {
+		handler = slog.NewTextHandler(out, options)
+	}
+	slog.SetDefault(slog.New(handler))
+}
+
+// loggerFrom returns the default logger annotated with the request id, client, tenant and trace id of the context
+func loggerFrom(ctx context.Context) *slog.Logger {
+	logger := slog.Default()
+	if requestID, ok := ctx.Value(requestIDKey).(string); ok {
+		logger = logger.With("request_id", requestID)
+	}
+	if p, ok := principalFrom(ctx); ok {
+		logger = logger.With("client", p.name)
+	}
+	if tenant := tenantFrom(ctx); tenant != "" {
+		logger = logger.With("tenant", tenant)
+	}
+	if s := spanFrom(ctx); s != nil {
+		logger = logger.With("trace_id", s.traceIDString())
+	}
+
+	return logger
+}
+
+// validRequestID returns true for request ids of printable ascii characters without spaces
+func validRequestID(requestID string) bool {
+	if requestID == "" || len(requestID) > maxRequestIDLength {
+		return false
+	}
+
+	return strings.IndexFunc(requestID, func(c rune) bool { return c <= ' ' || c > '~' }) < 0
+}
+
+// traceRequest gives every request an id, passed in by the client or generated, and a server span continuing the
+// trace of the client. Both are added to the request context and the request is logged when it is done.
+func traceRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(requestIDHeader)
+		if !validRequestID(requestID) {
+			requestID = randomToken(8)
+		}
+		w.Header().Set(requestIDHeader, requestID)
+
+		route := routeTemplate(r)
+		ctx := context.WithValue(r.Context(), requestIDKey, requestID)
+		ctx, s := startSpan(ctx, r.Method+" "+route, spanKindServer, parseTraceParent(r.Header.Get(traceParentHeader)))
+		s.setAttribute("http.method", r.Method)
+		s.setAttribute("http.route", route)
+		s.setAttribute("request.id", requestID)
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+
+		s.setAttribute("http.status_code", fmt.Sprint(recorder.status))
+		var spanErr error
+		if recorder.status >= http.StatusInternalServerError {
+			spanErr = fmt.Errorf("status %d", recorder.status)
+		}
+		s.end(spanErr)
+
+		level := slog.LevelInfo
+		if recorder.status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		loggerFrom(ctx).Log(ctx, level, "request",
+			"method", r.Method,
+			"route", route,
+			"path", r.URL.Path,
+			"status", recorder.status,
+			"duration_ms", time.Since(start).Milliseconds(),
+		)
+	})
+}
+
+// storageSession is a copy of the database session bound to the context of the request or background job it serves,
+// so that the storage operations called with it use the database of the tenant of the context and log and trace with
+// its request id. The context travels with the session instead of being looked up by it, copies keep it.
+type storageSession struct {
+	session *mgo.Session
+	ctx     context.Context
+}
+
+// requestSession returns a copy of the session bound to the request context. release closes the copy.
+func requestSession(r *http.Request) (m *storageSession, release func()) {
+	return bindSession(mongoClient, r.Context())
+}
+
+// bindSession returns a copy of the session bound to the context
+func bindSession(mongoClient *mgo.Session, ctx context.Context) (m *storageSession, release func()) {
+	m = &storageSession{session: mongoClient.Copy(), ctx: ctx}
+
+	return m, m.close
+}
+
+// copy returns another copy bound to the same context for work outliving the request, e.g. a webhook delivery. The
+// copy is not canceled with the request, whoever does the work closes it.
+func (m *storageSession) copy() *storageSession {
+	return &storageSession{session: m.session.Copy(), ctx: context.WithoutCancel(m.ctx)}
+}
+
+func (m *storageSession) close() {
+	m.session.Close()
+}
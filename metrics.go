@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	mgo "gopkg.in/mgo.v2"
+)
+
+const (
+	metricCounter   = "counter"
+	metricGauge     = "gauge"
+	metricHistogram = "histogram"
+
+	metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+// latencyBuckets are the upper bounds in seconds of the latency histograms
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// metricVec is a metric family in the Prometheus text format with one series per combination of label values
+type metricVec struct {
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*metricSeries
+}
+
+type metricSeries struct {
+	labelValues  []string
+	value        float64
+	bucketCounts []uint64
+	count        uint64
+}
+
+// registeredMetrics are written by the metrics endpoint in this order
+var registeredMetrics []*metricVec
+
+func newMetric(kind, name, help string, labels ...string) *metricVec {
+	metric := &metricVec{name: name, help: help, kind: kind, labels: labels, series: map[string]*metricSeries{}}
+	if kind == metricHistogram {
+		metric.buckets = latencyBuckets
+	}
+	registeredMetrics = append(registeredMetrics, metric)
+
+	return metric
+}
+
+var (
+	httpRequests         = newMetric(metricCounter, "http_requests_total", "HTTP requests by route, method and status code.", "route", "method", "code")
+	httpRequestDuration  = newMetric(metricHistogram, "http_request_duration_seconds", "HTTP request latency by route and method.", "route", "method")
+	httpRequestsInFlight = newMetric(metricGauge, "http_requests_in_flight", "HTTP requests currently being served.")
+	rejectedRequests     = newMetric(metricCounter, "http_requests_rejected_total", "Requests rejected by the rate limit or the size limits.", "reason")
+
+	storageOperationDuration = newMetric(metricHistogram, "storage_operation_duration_seconds", "Latency of the storage operations.", "operation")
+	storageErrors            = newMetric(metricCounter, "storage_errors_total", "Failed storage operations.", "operation")
+	storageDuplicateKeys     = newMetric(metricCounter, "storage_duplicate_keys_total", "Inserts rejected because the document already existed.", "collection")
+	reviewsIngested          = newMetric(metricCounter, "app_reviews_ingested_total", "New app reviews stored by package name.", "package_name")
+	documentsPurged          = newMetric(metricCounter, "storage_documents_purged_total", "Documents removed by the retention policies.", "collection")
+	collectionDocuments      = newMetric(metricGauge, "storage_collection_documents", "Number of documents per collection.", "collection")
+)
+
+func (v *metricVec) get(labelValues []string) *metricSeries {
+	key := strings.Join(labelValues, "\xff")
+	s, ok := v.series[key]
+	if !ok {
+		s = &metricSeries{labelValues: labelValues}
+		if v.kind == metricHistogram {
+			s.bucketCounts = make([]uint64, len(v.buckets))
+		}
+		v.series[key] = s
+	}
+
+	return s
+}
+
+// add increases a counter or gauge, gauges can be decreased with a negative delta
+func (v *metricVec) add(delta float64, labelValues ...string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.get(labelValues).value += delta
+}
+
+func (v *metricVec) set(value float64, labelValues ...string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.get(labelValues).value = value
+}
+
+// observe records a value of a histogram
+func (v *metricVec) observe(value float64, labelValues ...string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	s := v.get(labelValues)
+	for i, upperBound := range v.buckets {
+		if value <= upperBound {
+			s.bucketCounts[i]++
+		}
+	}
+	s.count++
+	s.value += value
+}
+
+// write writes the metric family in the Prometheus text format
+func (v *metricVec) write(w io.Writer) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", v.name, v.help, v.name, v.kind)
+	keys := make([]string, 0, len(v.series))
+	for key := range v.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s := v.series[key]
+		if v.kind != metricHistogram {
+			fmt.Fprintf(w, "%s%s %s\n", v.name, formatLabels(v.labels, s.labelValues, "", ""), formatMetricValue(s.value))
+			continue
+		}
+		for i, upperBound := range v.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", v.name, formatLabels(v.labels, s.labelValues, "le", formatMetricValue(upperBound)), s.bucketCounts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", v.name, formatLabels(v.labels, s.labelValues, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", v.name, formatLabels(v.labels, s.labelValues, "", ""), formatMetricValue(s.value))
+		fmt.Fprintf(w, "%s_count%s %d\n", v.name, formatLabels(v.labels, s.labelValues, "", ""), s.count)
+	}
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(names, values []string, extraName, extraValue string) string {
+	var pairs []string
+	for i, name := range names {
+		pairs = append(pairs, name+`="`+labelValueEscaper.Replace(values[i])+`"`)
+	}
+	if extraName != "" {
+		pairs = append(pairs, extraName+`="`+extraValue+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatMetricValue(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// writeMetrics writes all registered metrics
+func writeMetrics(w io.Writer) {
+	for _, metric := range registeredMetrics {
+		metric.write(w)
+	}
+}
+
+// updateCollectionDocumentGauges counts the documents of every collection over all tenants
+func updateCollectionDocumentGauges(mongoClient *mgo.Session) {
+	counts := map[string]int{}
+	failed := map[string]bool{}
+	forEachTenant(mongoClient, func(m *storageSession) {
+		counted := map[string]bool{}
+		for _, i := range mongoCollectionIndexes() {
+			if counted[i.collection] {
+				continue
+			}
+			counted[i.collection] = true
+			op := startStorageOperation(m, "count_documents")
+			count, err := mongoDatabase(m).C(i.collection).Count()
+			if err != nil {
+				op.fail(err)
+				failed[i.collection] = true
+			}
+			counts[i.collection] += count
+			op.done()
+		}
+	})
+	for collection, count := range counts {
+		if !failed[collection] {
+			collectionDocuments.set(float64(count), collection)
+		}
+	}
+}
+
+// storageOperation measures and traces one call of a storage function with the request context bound to the session
+type storageOperation struct {
+	name  string
+	start time.Time
+	ctx   context.Context
+	span  *span
+	err   error
+}
+
+func startStorageOperation(mongoClient *storageSession, name string) *storageOperation {
+	ctx, s := startSpan(mongoClient.ctx, "mongo "+name, spanKindClient, nil)
+	s.setAttribute("db.system", "mongodb")
+	s.setAttribute("db.name", tenantDatabaseName(tenantFrom(ctx)))
+	s.setAttribute("db.operation", name)
+
+	return &storageOperation{name: name, start: time.Now(), ctx: ctx, span: s}
+}
+
+func (op *storageOperation) done() {
+	storageOperationDuration.observe(time.Since(op.start).Seconds(), op.name)
+	op.span.end(op.err)
+}
+
+// fail logs and counts an error of the operation
+func (op *storageOperation) fail(err error) {
+	op.err = err
+	loggerFrom(op.ctx).Error("storage operation failed", "operation", op.name, "error", err)
+	storageErrors.add(1, op.name)
+}
+
+// check counts the error the operation returns to its caller
+func (op *storageOperation) check(err error) error {
+	if err != nil {
+		op.err = err
+		storageErrors.add(1, op.name)
+	}
+
+	return err
+}
+
+// statusRecorder remembers the status code of a response and keeps streaming responses flushable
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// instrumentRoute records the request metrics labeled with the route template instead of the path, so that path
+// parameters like package names do not create a series each
+func instrumentRoute(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := routeTemplate(r)
+		httpRequestsInFlight.add(1)
+		defer httpRequestsInFlight.add(-1)
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		next.ServeHTTP(recorder, r)
+		httpRequestDuration.observe(time.Since(start).Seconds(), route, r.Method)
+		httpRequests.add(1, route, r.Method, strconv.Itoa(recorder.status))
+	})
+}
+
+// routeTemplate returns the path template of the route matching the request
+func routeTemplate(r *http.Request) string {
+	if current := mux.CurrentRoute(r); current != nil {
+		if template, err := current.GetPathTemplate(); err == nil {
+			return template
+		}
+	}
+
+	return "unknown"
+}
@@ -1,63 +1,284 @@
 package main
 
+import "time"
+
 // AppPageGooglePlay model
 type AppPageGooglePlay struct {
-	Name                    string             `json:"name" bson:"name"`
-	PackageName             string             `json:"package_name" bson:"package_name"`
-	DateCrawled             int64              `json:"date_crawled" bson:"date_crawled"`
-	Category                string             `json:"category" bson:"category"`
-	USK                     string             `json:"usk" bson:"usk"`
-	Price                   string             `json:"price" bson:"price"`
-	PriceValue              float64            `json:"price_value" bson:"price_value"`
-	PriceCurrency           string             `json:"price_currency" bson:"price_currency"`
-	Description             string             `json:"description" bson:"description"`
-	WhatsNew                []string           `json:"whats_new" bson:"whats_new"`
-	Rating                  float64            `json:"rating" bson:"rating"`
-	StarsCount              int64              `json:"stars_count" bson:"stars_count"`
-	CountPerRating          StarCountPerRating `json:"count_per_rating" bson:"count_per_rating"`
-	EstimatedDownloadNumber int64              `json:"estimated_download_number" bson:"estimated_download_number"`
-	DeveloperName           string             `json:"developer" bson:"developer"`
-	TopDeveloper            bool               `json:"top_developer" bson:"top_developer"`
-	ContainsAds             bool               `json:"contains_ads" bson:"contains_ads"`
-	InAppPurchases          bool               `json:"in_app_purchase" bson:"in_app_purchase"`
-	LastUpdate              int64              `json:"last_update" bson:"last_update"`
-	Os                      string             `json:"os" bson:"os"`
-	RequiresOsVersion       string             `json:"requires_os_version" bson:"requires_os_version"`
-	CurrentSoftwareVersion  string             `json:"current_software_version" bson:"current_software_version"`
-	SimilarApps             []string           `json:"similar_apps" bson:"similar_apps"`
+	Name                    string             `json:"name" bson:"name" proto:"1"`
+	PackageName             string             `json:"package_name" bson:"package_name" proto:"2"`
+	DateCrawled             int64              `json:"date_crawled" bson:"date_crawled" proto:"3"`
+	Category                string             `json:"category" bson:"category" proto:"4"`
+	USK                     string             `json:"usk" bson:"usk" proto:"5"`
+	Price                   string             `json:"price" bson:"price" proto:"6"`
+	PriceValue              float64            `json:"price_value" bson:"price_value" proto:"7"`
+	PriceCurrency           string             `json:"price_currency" bson:"price_currency" proto:"8"`
+	Description             string             `json:"description" bson:"description" proto:"9"`
+	WhatsNew                []string           `json:"whats_new" bson:"whats_new" proto:"10"`
+	Rating                  float64            `json:"rating" bson:"rating" proto:"11"`
+	StarsCount              int64              `json:"stars_count" bson:"stars_count" proto:"12"`
+	CountPerRating          StarCountPerRating `json:"count_per_rating" bson:"count_per_rating" proto:"13"`
+	EstimatedDownloadNumber int64              `json:"estimated_download_number" bson:"estimated_download_number" proto:"14"`
+	DeveloperName           string             `json:"developer" bson:"developer" proto:"15"`
+	TopDeveloper            bool               `json:"top_developer" bson:"top_developer" proto:"16"`
+	ContainsAds             bool               `json:"contains_ads" bson:"contains_ads" proto:"17"`
+	InAppPurchases          bool               `json:"in_app_purchase" bson:"in_app_purchase" proto:"18"`
+	LastUpdate              int64              `json:"last_update" bson:"last_update" proto:"19"`
+	Os                      string             `json:"os" bson:"os" proto:"20"`
+	RequiresOsVersion       string             `json:"requires_os_version" bson:"requires_os_version" proto:"21"`
+	CurrentSoftwareVersion  string             `json:"current_software_version" bson:"current_software_version" proto:"22"`
+	SimilarApps             []string           `json:"similar_apps" bson:"similar_apps" proto:"23"`
+	StoredAt                time.Time          `json:"-" bson:"stored_at,omitempty"`
 }
 
 // StarCountPerRating model
 type StarCountPerRating struct {
-	Five  int `json:"5"`
-	Four  int `json:"4"`
-	Three int `json:"3"`
-	Two   int `json:"2"`
-	One   int `json:"1"`
+	Five  int `json:"5" proto:"5"`
+	Four  int `json:"4" proto:"4"`
+	Three int `json:"3" proto:"3"`
+	Two   int `json:"2" proto:"2"`
+	One   int `json:"1" proto:"1"`
 }
 
 // AppReviewGooglePlay model
 type AppReviewGooglePlay struct {
-	ReviewID       string `json:"review_id" bson:"review_id"`
-	PackageName    string `json:"package_name" bson:"package_name"`
-	Author         string `json:"author" bson:"author"`
-	Date           int64  `json:"date_posted" bson:"date_posted"`
-	Rating         int    `json:"rating" bson:"rating"`
-	Title          string `json:"title" bson:"title"`
-	Body           string `json:"body" bson:"body"`
-	PermaLink      string `json:"perma_link" bson:"perma_link"`
-	FeatureRequest bool   `json:"cluster_is_feature_request" bson:"cluster_is_feature_request"`
-	BugReport      bool   `json:"cluster_is_bug_report" bson:"cluster_is_bug_report"`
+	ReviewID       string        `json:"review_id" bson:"review_id" proto:"1"`
+	PackageName    string        `json:"package_name" bson:"package_name" proto:"2"`
+	Author         string        `json:"author" bson:"author" proto:"3"`
+	Date           int64         `json:"date_posted" bson:"date_posted" proto:"4"`
+	Rating         int           `json:"rating" bson:"rating" proto:"5"`
+	Title          string        `json:"title" bson:"title" proto:"6"`
+	Body           string        `json:"body" bson:"body" proto:"7"`
+	PermaLink      string        `json:"perma_link" bson:"perma_link" proto:"8"`
+	FeatureRequest bool          `json:"cluster_is_feature_request" bson:"cluster_is_feature_request" proto:"9"`
+	BugReport      bool          `json:"cluster_is_bug_report" bson:"cluster_is_bug_report" proto:"10"`
+	Labels         []ReviewLabel `json:"labels,omitempty" bson:"labels,omitempty" proto:"11"`
+	Fingerprint    string        `json:"fingerprint,omitempty" bson:"fingerprint,omitempty" proto:"12"`
+	DuplicateOf    string        `json:"duplicate_of,omitempty" bson:"duplicate_of,omitempty" proto:"13"`
+	DuplicateType  string        `json:"duplicate_type,omitempty" bson:"duplicate_type,omitempty" proto:"14"`
+	MinHash        []uint32      `json:"-" bson:"minhash,omitempty"`
+	MinHashBands   []string      `json:"-" bson:"minhash_bands,omitempty"`
+	StoredAt       time.Time     `json:"-" bson:"stored_at,omitempty"`
+}
+
+// ReviewLabel model
+type ReviewLabel struct {
+	Name       string  `json:"name" bson:"name" proto:"1"`
+	Confidence float64 `json:"confidence" bson:"confidence" proto:"2"`
+	Source     string  `json:"source" bson:"source" proto:"3"`
+}
+
+// ReviewCluster model
+type ReviewCluster struct {
+	ClusterID             int                   `json:"cluster_id"`
+	Size                  int                   `json:"size"`
+	KeyTerms              []string              `json:"key_terms"`
+	RepresentativeReviews []AppReviewGooglePlay `json:"representative_reviews"`
+	ReviewIDs             []string              `json:"review_ids"`
 }
 
 // ObservableGooglePlay model
 type ObservableGooglePlay struct {
-	PackageName string `json:"package_name" bson:"package_name"`
-	Interval    string `json:"interval" bson:"interval"`
+	PackageName string `json:"package_name" bson:"package_name" proto:"1"`
+	Interval    string `json:"interval" bson:"interval" proto:"2"`
+}
+
+// AlertRuleGooglePlay model
+type AlertRuleGooglePlay struct {
+	PackageName   string  `json:"package_name" bson:"package_name"`
+	Type          string  `json:"type" bson:"type"`
+	Class         string  `json:"class,omitempty" bson:"class,omitempty"`
+	Threshold     float64 `json:"threshold" bson:"threshold"`
+	MinCount      int     `json:"min_count" bson:"min_count"`
+	WindowDays    int     `json:"window_days" bson:"window_days"`
+	BaselineDays  int     `json:"baseline_days" bson:"baseline_days"`
+	WebhookURL    string  `json:"webhook_url" bson:"webhook_url"`
+	LastTriggered int64   `json:"last_triggered" bson:"last_triggered"`
+}
+
+// AlertGooglePlay model
+type AlertGooglePlay struct {
+	PackageName string  `json:"package_name" bson:"package_name"`
+	Type        string  `json:"type" bson:"type"`
+	Message     string  `json:"message" bson:"message"`
+	Value       float64 `json:"value" bson:"value"`
+	Baseline    float64 `json:"baseline" bson:"baseline"`
+	TriggeredAt int64   `json:"triggered_at" bson:"triggered_at"`
+	Delivered   bool    `json:"delivered" bson:"delivered"`
+}
+
+// SubscriptionGooglePlay model
+type SubscriptionGooglePlay struct {
+	SubscriptionID string   `json:"subscription_id" bson:"subscription_id"`
+	URL            string   `json:"url" bson:"url"`
+	Secret         string   `json:"secret,omitempty" bson:"secret"`
+	Events         []string `json:"events,omitempty" bson:"events,omitempty"`
+	PackageName    string   `json:"package_name,omitempty" bson:"package_name,omitempty"`
+	Class          string   `json:"class,omitempty" bson:"class,omitempty"`
+	MinRating      int      `json:"min_rating,omitempty" bson:"min_rating,omitempty"`
+	CreatedAt      int64    `json:"created_at" bson:"created_at"`
+}
+
+// WebhookNotification model
+type WebhookNotification struct {
+	SubscriptionID string      `json:"subscription_id"`
+	Event          string      `json:"event"`
+	SentAt         int64       `json:"sent_at"`
+	Data           interface{} `json:"data"`
+}
+
+// WebhookDeliveryGooglePlay model
+type WebhookDeliveryGooglePlay struct {
+	SubscriptionID string `json:"subscription_id" bson:"subscription_id"`
+	Event          string `json:"event" bson:"event"`
+	URL            string `json:"url" bson:"url"`
+	ItemCount      int    `json:"item_count" bson:"item_count"`
+	Attempts       int    `json:"attempts" bson:"attempts"`
+	StatusCode     int    `json:"status_code" bson:"status_code"`
+	Error          string `json:"error,omitempty" bson:"error,omitempty"`
+	Delivered      bool   `json:"delivered" bson:"delivered"`
+	CreatedAt      int64  `json:"created_at" bson:"created_at"`
+	FinishedAt     int64  `json:"finished_at" bson:"finished_at"`
+}
+
+// OutboxEvent model
+type OutboxEvent struct {
+	// ID identifies the event across tenants, the sequence numbers count per tenant
+	ID        string      `json:"id" bson:"-"`
+	Sequence  int64       `json:"sequence" bson:"sequence"`
+	Tenant    string      `json:"tenant" bson:"tenant,omitempty"`
+	Type      string      `json:"type" bson:"type"`
+	Key       string      `json:"key" bson:"key"`
+	Payload   interface{} `json:"payload" bson:"payload"`
+	CreatedAt int64       `json:"created_at" bson:"created_at"`
+}
+
+// ImportSummary model
+type ImportSummary struct {
+	Read          int               `json:"read" proto:"1"`
+	Inserted      int               `json:"inserted" proto:"2"`
+	Updated       int               `json:"updated" proto:"3"`
+	Existing      int               `json:"existing" proto:"4"`
+	Rejected      int               `json:"rejected" proto:"5"`
+	RejectedLines []ImportRejection `json:"rejected_lines" proto:"6"`
+}
+
+// ImportRejection model
+type ImportRejection struct {
+	Line  int    `json:"line" proto:"1"`
+	Error string `json:"error" proto:"2"`
+}
+
+// FieldError model
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ErasureRequest model
+type ErasureRequest struct {
+	Author string `json:"author"`
+	Mode   string `json:"mode,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// ErasureGooglePlay model, the author is recorded as a hash only
+type ErasureGooglePlay struct {
+	ErasureID    string `json:"erasure_id" bson:"erasure_id"`
+	AuthorSHA256 string `json:"author_sha256" bson:"author_sha256"`
+	Mode         string `json:"mode" bson:"mode"`
+	Reason       string `json:"reason,omitempty" bson:"reason,omitempty"`
+	AppReviews   int    `json:"app_reviews" bson:"app_reviews"`
+	OutboxEvents int    `json:"outbox_events" bson:"outbox_events"`
+	RequestedBy  string `json:"requested_by" bson:"requested_by"`
+	ErasedAt     int64  `json:"erased_at" bson:"erased_at"`
+}
+
+// AuditEntryGooglePlay model, it records a write of a client. The keys identify the written documents, e.g. the review
+// ids of stored reviews, and the counts tell how many documents were affected, e.g. how many reviews were inserted.
+type AuditEntryGooglePlay struct {
+	AuditID     string         `json:"audit_id" bson:"audit_id"`
+	Action      string         `json:"action" bson:"action"`
+	Route       string         `json:"route" bson:"route"`
+	Client      string         `json:"client" bson:"client"`
+	RequestID   string         `json:"request_id,omitempty" bson:"request_id,omitempty"`
+	Keys        []string       `json:"keys" bson:"keys"`
+	KeysOmitted int            `json:"keys_omitted,omitempty" bson:"keys_omitted,omitempty"`
+	Counts      map[string]int `json:"counts,omitempty" bson:"counts,omitempty"`
+	Failed      bool           `json:"failed,omitempty" bson:"failed,omitempty"`
+	Timestamp   int64          `json:"timestamp" bson:"timestamp"`
+}
+
+// AppReviewQuery model
+type AppReviewQuery struct {
+	PackageName       string  `json:"package_name" proto:"1"`
+	Class             string  `json:"class,omitempty" proto:"2"`
+	MinConfidence     float64 `json:"min_confidence,omitempty" proto:"3"`
+	ExcludeDuplicates bool    `json:"exclude_duplicates,omitempty" proto:"4"`
+	From              int64   `json:"from,omitempty" proto:"5"`
+	To                int64   `json:"to,omitempty" proto:"6"`
+	Limit             int     `json:"limit,omitempty" proto:"7"`
+}
+
+// ObservableList model
+type ObservableList struct {
+	Observables []ObservableGooglePlay `json:"observables" proto:"1"`
+}
+
+// Empty model
+type Empty struct{}
+
+// StatisticsGooglePlay model
+type StatisticsGooglePlay struct {
+	PackageName     string `json:"package_name,omitempty"`
+	AppReviews      int    `json:"app_reviews"`
+	BugReports      int    `json:"bug_reports"`
+	FeatureRequests int    `json:"feature_requests"`
+	Duplicates      int    `json:"duplicates"`
+	AppPages        int    `json:"app_pages"`
+	Observables     int    `json:"observables"`
+}
+
+// HealthStatus model
+type HealthStatus struct {
+	Status        string        `json:"status"`
+	Version       string        `json:"version"`
+	GoVersion     string        `json:"go_version"`
+	UptimeSeconds int64         `json:"uptime_seconds"`
+	Checks        []HealthCheck `json:"checks"`
+}
+
+// HealthCheck model
+type HealthCheck struct {
+	Name           string   `json:"name"`
+	Status         string   `json:"status"`
+	Error          string   `json:"error,omitempty"`
+	MissingIndexes []string `json:"missing_indexes,omitempty"`
+}
+
+// GraphQLRequest model
+type GraphQLRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+}
+
+// GraphQLResponse model
+type GraphQLResponse struct {
+	Data   interface{}    `json:"data,omitempty"`
+	Errors []GraphQLError `json:"errors,omitempty"`
+}
+
+// GraphQLError model
+type GraphQLError struct {
+	Message string        `json:"message"`
+	Path    []interface{} `json:"path,omitempty"`
 }
 
 // ResponseRecentData model
 type ResponseRecentData struct {
-	Message string `json:"message"`
-	Status  bool   `json:"status"`
+	Message   string      `json:"message"`
+	Status    bool        `json:"status"`
+	Code      string      `json:"code,omitempty"`
+	Details   interface{} `json:"details,omitempty"`
+	RequestID string      `json:"request_id,omitempty"`
 }
@@ -2,127 +2,284 @@ package main
 
 import (
 	"fmt"
-	"log"
+	"strings"
 	"time"
 
 	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 )
 
-const (
-	database                       = "app_data"
-	collectionAppReviewsGooglePlay = "app_reviews_google_play"
-	collectionAppPageGooglePlay    = "app_page_google_play"
-	collectionObservableGooglePlay = "observable_google_play"
+// database and collection names, they can be changed by the configuration
+var (
+	database                            = "app_data"
+	collectionAppReviewsGooglePlay      = "app_reviews_google_play"
+	collectionAppPageGooglePlay         = "app_page_google_play"
+	collectionObservableGooglePlay      = "observable_google_play"
+	collectionAlertRuleGooglePlay       = "alert_rule_google_play"
+	collectionAlertGooglePlay           = "alert_google_play"
+	collectionSubscriptionGooglePlay    = "subscription_google_play"
+	collectionWebhookDeliveryGooglePlay = "webhook_delivery_google_play"
+	collectionOutbox                    = "outbox"
+	collectionOutboxOffset              = "outbox_offset"
+	collectionCounter                   = "counter"
+	collectionErasure                   = "erasure"
+	collectionAudit                     = "audit"
 )
 
-// MongoGetSession returns a session
-func MongoGetSession(mongoIP, username, password string) *mgo.Session {
-	info := &mgo.DialInfo{
-		Addrs:    []string{mongoIP},
-		Timeout:  60 * time.Second,
-		Database: database,
-		Username: username,
-		Password: password,
+// MongoGetSession returns a session or an error if the database is not reachable
+func MongoGetSession(mongoConfig MongoConfig) (*mgo.Session, error) {
+	info, err := mongoDialInfo(mongoConfig)
+	if err != nil {
+		return nil, err
 	}
 
 	session, err := mgo.DialWithInfo(info)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
 	session.SetMode(mgo.Monotonic, true)
+	if mongoConfig.SocketTimeout.Duration > 0 {
+		session.SetSocketTimeout(mongoConfig.SocketTimeout.Duration)
+	}
 
-	return session
+	return session, nil
 }
 
-// MongoCreateCollectionIndexes creates the indexes
-func MongoCreateCollectionIndexes(mongoClient *mgo.Session) {
-	// Index
-	appReviewGooglePlayUniqueIndex := mgo.Index{
-		Key:        []string{"review_id"},
-		Unique:     true,
-		Background: true,
-		Sparse:     true,
+// collectionIndex is an index the service needs on a collection
+type collectionIndex struct {
+	collection string
+	index      mgo.Index
+}
+
+// mongoCollectionIndexes returns the indexes of all collections
+func mongoCollectionIndexes() []collectionIndex {
+	index := func(collection string, unique bool, key ...string) collectionIndex {
+		return collectionIndex{collection, mgo.Index{
+			Key:        key,
+			Unique:     unique,
+			Background: true,
+			Sparse:     true,
+		}}
 	}
 
-	// Index
-	appReviewGooglePlayIndex := mgo.Index{
-		Key:        []string{"date_posted"},
-		Unique:     false,
-		Background: true,
-		Sparse:     true,
+	return []collectionIndex{
+		index(collectionAppReviewsGooglePlay, true, "review_id"),
+		index(collectionAppReviewsGooglePlay, false, "date_posted"),
+		index(collectionAppReviewsGooglePlay, false, "package_name", "labels.name"),
+		index(collectionAppReviewsGooglePlay, false, "package_name", "fingerprint"),
+		index(collectionAppReviewsGooglePlay, false, "package_name", "minhash_bands"),
+		index(collectionAppReviewsGooglePlay, false, "author"),
+		index(collectionAppPageGooglePlay, true, "package_name", "last_update"),
+		index(collectionObservableGooglePlay, true, "package_name"),
+		index(collectionAlertRuleGooglePlay, true, "package_name", "type"),
+		index(collectionAlertGooglePlay, false, "package_name", "-triggered_at"),
+		index(collectionSubscriptionGooglePlay, true, "subscription_id"),
+		index(collectionWebhookDeliveryGooglePlay, false, "subscription_id", "-created_at"),
+		index(collectionOutbox, true, "sequence"),
+		index(collectionErasure, true, "erasure_id"),
+		index(collectionAudit, true, "audit_id"),
+		index(collectionAudit, false, "-timestamp"),
+		index(collectionAudit, false, "keys", "-timestamp"),
 	}
-	appReviewGooglePlayCollection := mongoClient.DB(database).C(collectionAppReviewsGooglePlay)
-	err := appReviewGooglePlayCollection.EnsureIndex(appReviewGooglePlayUniqueIndex)
-	if err != nil {
-		panic(err)
+}
+
+// MongoCreateCollectionIndexes creates the indexes
+func MongoCreateCollectionIndexes(mongoClient *storageSession) {
+	for _, i := range mongoCollectionIndexes() {
+		err := mongoDatabase(mongoClient).C(i.collection).EnsureIndex(i.index)
+		if err != nil {
+			panic(err)
+		}
 	}
-	err = appReviewGooglePlayCollection.EnsureIndex(appReviewGooglePlayIndex)
-	if err != nil {
+	if err := mongoEnsureRetentionIndexes(mongoClient); err != nil {
 		panic(err)
 	}
+}
 
-	// Index
-	appPageGooglePlayIndex := mgo.Index{
-		Key:        []string{"package_name", "last_update"},
-		Unique:     true,
-		Background: true,
-		Sparse:     true,
-	}
-	appPageGooglePlayCollection := mongoClient.DB(database).C(collectionAppPageGooglePlay)
-	err = appPageGooglePlayCollection.EnsureIndex(appPageGooglePlayIndex)
-	if err != nil {
-		panic(err)
+// mongoEnsureRetentionIndexes creates the TTL indexes of the collections whose retention mode is ttl, changes their
+// expiry if the max age changed and drops them if the collection is purged instead
+func mongoEnsureRetentionIndexes(mongoClient *storageSession) error {
+	db := mongoDatabase(mongoClient)
+	for _, target := range retentionTargets() {
+		col := db.C(target.collection)
+		indexes, err := col.Indexes()
+		if err != nil && !isMongoNamespaceNotFound(err) {
+			return err
+		}
+		var existing *mgo.Index
+		for i := range indexes {
+			if strings.Join(indexes[i].Key, ",") == "stored_at" {
+				existing = &indexes[i]
+			}
+		}
+
+		expireAfter := target.policy.MaxAge.Duration.Truncate(time.Second)
+		ttl := target.policy.Mode == retentionTTL && expireAfter > 0
+		switch {
+		case ttl && existing == nil:
+			err = col.EnsureIndex(mgo.Index{Key: []string{"stored_at"}, ExpireAfter: expireAfter, Background: true})
+		case ttl && existing.ExpireAfter != expireAfter:
+			err = db.Run(bson.D{
+				{Name: "collMod", Value: target.collection},
+				{Name: "index", Value: bson.M{"name": existing.Name, "expireAfterSeconds": int(expireAfter.Seconds())}},
+			}, nil)
+		case !ttl && existing != nil:
+			err = col.DropIndexName(existing.Name)
+		}
+		if err != nil {
+			return err
+		}
 	}
 
-	// Index
-	observableGooglePlayIndex := mgo.Index{
-		Key:        []string{"package_name"},
-		Unique:     true,
-		Background: true,
-		Sparse:     true,
+	return nil
+}
+
+// MongoGetMissingIndexes returns the indexes that do not exist yet in the form collection: key, prefixed with the
+// database for tenants other than the default tenant
+func MongoGetMissingIndexes(mongoClient *storageSession) ([]string, error) {
+	prefix := ""
+	if tenant := tenantFrom(mongoClient.ctx); tenant != "" {
+		prefix = tenantDatabaseName(tenant) + "."
 	}
-	observableGoogleCollection := mongoClient.DB(database).C(collectionObservableGooglePlay)
-	err = observableGoogleCollection.EnsureIndex(observableGooglePlayIndex)
-	if err != nil {
-		panic(err)
+	existing := map[string]bool{}
+	listed := map[string]bool{}
+	var missing []string
+	for _, i := range mongoCollectionIndexes() {
+		if !listed[i.collection] {
+			indexes, err := mongoDatabase(mongoClient).C(i.collection).Indexes()
+			if err != nil && !isMongoNamespaceNotFound(err) {
+				return nil, err
+			}
+			for _, index := range indexes {
+				existing[i.collection+": "+strings.Join(index.Key, ",")] = true
+			}
+			listed[i.collection] = true
+		}
+
+		name := i.collection + ": " + strings.Join(i.index.Key, ",")
+		if !existing[name] {
+			missing = append(missing, prefix+name)
+		}
 	}
+
+	return missing, nil
+}
+
+// isMongoNamespaceNotFound returns true for the error of listing the indexes of a collection that does not exist yet
+func isMongoNamespaceNotFound(err error) bool {
+	queryErr, ok := err.(*mgo.QueryError)
+	return ok && queryErr.Code == 26
 }
 
 // MongoInsertAppPageGooglePlay returns ok if the app page was inserted or already existed
-func MongoInsertAppPageGooglePlay(mongoClient *mgo.Session, appPage AppPageGooglePlay) bool {
-	err := mongoClient.DB(database).C(collectionAppPageGooglePlay).Insert(appPage)
+// and isNew if it did not exist before
+func MongoInsertAppPageGooglePlay(mongoClient *storageSession, appPage AppPageGooglePlay) (isNew bool, ok bool) {
+	op := startStorageOperation(mongoClient, "insert_app_page")
+	defer op.done()
+
+	appPage.StoredAt = time.Now()
+	err := mongoDatabase(mongoClient).C(collectionAppPageGooglePlay).Insert(appPage)
 	if err != nil && !mgo.IsDup(err) {
-		fmt.Println(err)
-		return false
+		op.fail(err)
+		return false, false
+	}
+	if err != nil {
+		storageDuplicateKeys.add(1, collectionAppPageGooglePlay)
+	} else {
+		mongoWriteOutboxEvent(mongoClient, outboxEventAppPageInserted, appPage.PackageName, appPage)
 	}
 
-	return true
+	return err == nil, true
 }
 
-// MongoInsertAppReviewGooglePlay returns ok if the review was inserted or updated
-func MongoInsertAppReviewGooglePlay(mongoClient *mgo.Session, review AppReviewGooglePlay) bool {
-	col := mongoClient.DB(database).C(collectionAppReviewsGooglePlay)
+// MongoInsertAppReviewGooglePlay returns ok if the review was inserted or updated and isNew if it was inserted.
+// Reviews repeating the text of an already stored review are flagged as exact or near duplicates.
+func MongoInsertAppReviewGooglePlay(mongoClient *storageSession, review AppReviewGooglePlay) (isNew bool, ok bool) {
+	op := startStorageOperation(mongoClient, "insert_app_review")
+	defer op.done()
+
+	review = syncReviewLabels(review)
+	review = fingerprintReview(review)
+	review.StoredAt = time.Now()
+	col := mongoDatabase(mongoClient).C(collectionAppReviewsGooglePlay)
+	review.DuplicateOf, review.DuplicateType = mongoFindDuplicateOf(mongoClient, col, review)
 	change := mgo.Change{
 		Update:    review,
 		Upsert:    true,
 		ReturnNew: true,
 	}
 	var newReview AppReviewGooglePlay
-	_, err := col.Find(bson.M{"review_id": review.ReviewID}).Apply(change, &newReview)
+	info, err := col.Find(bson.M{"review_id": review.ReviewID}).Apply(change, &newReview)
 	if err != nil {
-		fmt.Println(err)
-		return false
+		op.fail(err)
+		return false, false
+	}
+	isNew = info.UpsertedId != nil
+	if isNew {
+		reviewsIngested.add(1, newReview.PackageName)
+		mongoWriteOutboxEvent(mongoClient, outboxEventAppReviewInserted, newReview.ReviewID, newReview)
+	} else {
+		mongoWriteOutboxEvent(mongoClient, outboxEventAppReviewUpdated, newReview.ReviewID, newReview)
 	}
 
-	return true
+	return isNew, true
+}
+
+// mongoFindDuplicateOf returns the review id of the original review and the duplicate type
+// if the given review repeats the text of another review of the same package
+func mongoFindDuplicateOf(mongoClient *storageSession, col *mgo.Collection, review AppReviewGooglePlay) (string, string) {
+	if review.Fingerprint == "" {
+		return "", ""
+	}
+
+	// exact duplicates share the fingerprint
+	var original AppReviewGooglePlay
+	err := col.Find(bson.M{
+		"package_name": review.PackageName,
+		"review_id":    bson.M{"$ne": review.ReviewID},
+		"duplicate_of": bson.M{"$exists": false},
+		"fingerprint":  review.Fingerprint,
+	}).Sort("date_posted").One(&original)
+	if err == nil {
+		return original.ReviewID, duplicateTypeExact
+	}
+	if err != mgo.ErrNotFound {
+		loggerFrom(mongoClient.ctx).Error("could not look up duplicates", "review_id", review.ReviewID, "error", err)
+		return "", ""
+	}
+
+	// near duplicates share at least one min hash band and are similar enough
+	if len(review.MinHashBands) == 0 {
+		return "", ""
+	}
+	var candidates []AppReviewGooglePlay
+	err = col.Find(bson.M{
+		"package_name":  review.PackageName,
+		"review_id":     bson.M{"$ne": review.ReviewID},
+		"duplicate_of":  bson.M{"$exists": false},
+		"minhash_bands": bson.M{"$in": review.MinHashBands},
+	}).Select(bson.M{"review_id": 1, "minhash": 1}).Sort("date_posted").Limit(nearDuplicateCandidates).All(&candidates)
+	if err != nil {
+		loggerFrom(mongoClient.ctx).Error("could not look up duplicates", "review_id", review.ReviewID, "error", err)
+		return "", ""
+	}
+	for _, candidate := range candidates {
+		if estimateSimilarity(review.MinHash, candidate.MinHash) >= nearDuplicateThreshold {
+			return candidate.ReviewID, duplicateTypeNear
+		}
+	}
+
+	return "", ""
 }
 
 // MongoGetNonExistingAppReviewGooglePlay returns a list of app reviews that do not yet exist in the db
-func MongoGetNonExistingAppReviewGooglePlay(mongoClient *mgo.Session, reviews []AppReviewGooglePlay) []AppReviewGooglePlay {
+func MongoGetNonExistingAppReviewGooglePlay(mongoClient *storageSession, reviews []AppReviewGooglePlay) []AppReviewGooglePlay {
+	op := startStorageOperation(mongoClient, "get_non_existing_app_review")
+	defer op.done()
+
 	var uniqueAppReviews []AppReviewGooglePlay
-	col := mongoClient.DB(database).C(collectionAppReviewsGooglePlay)
+	col := mongoDatabase(mongoClient).C(collectionAppReviewsGooglePlay)
 
 	for _, review := range reviews {
 		var entry AppReviewGooglePlay
@@ -137,50 +294,932 @@ func MongoGetNonExistingAppReviewGooglePlay(mongoClient *mgo.Session, reviews []
 }
 
 // MongoInsertObservableGooglePlay returns ok if the package name was inserted or already existed
-func MongoInsertObservableGooglePlay(mongoClient *mgo.Session, observable ObservableGooglePlay) bool {
-	err := mongoClient.DB(database).C(collectionObservableGooglePlay).Insert(observable)
+func MongoInsertObservableGooglePlay(mongoClient *storageSession, observable ObservableGooglePlay) bool {
+	op := startStorageOperation(mongoClient, "insert_observable")
+	defer op.done()
+
+	err := mongoDatabase(mongoClient).C(collectionObservableGooglePlay).Insert(observable)
 	if err != nil && !mgo.IsDup(err) {
-		fmt.Println(err)
+		op.fail(err)
 		return false
 	}
+	if err != nil {
+		storageDuplicateKeys.add(1, collectionObservableGooglePlay)
+	} else {
+		mongoWriteOutboxEvent(mongoClient, outboxEventObservableInserted, observable.PackageName, observable)
+	}
 
 	return true
 }
 
+// MongoUpsertObservableGooglePlay inserts the observable or changes the interval of the observed package name. It
+// returns isNew if the package name was not observed yet and ok if no error occurred.
+func MongoUpsertObservableGooglePlay(mongoClient *storageSession, observable ObservableGooglePlay) (isNew bool, ok bool) {
+	op := startStorageOperation(mongoClient, "upsert_observable")
+	defer op.done()
+
+	info, err := mongoDatabase(mongoClient).
+		C(collectionObservableGooglePlay).
+		Upsert(bson.M{"package_name": observable.PackageName}, observable)
+	if err != nil {
+		op.fail(err)
+		return false, false
+	}
+	isNew = info.UpsertedId != nil
+	if isNew {
+		mongoWriteOutboxEvent(mongoClient, outboxEventObservableInserted, observable.PackageName, observable)
+	} else {
+		mongoWriteOutboxEvent(mongoClient, outboxEventObservableUpdated, observable.PackageName, observable)
+	}
+
+	return isNew, true
+}
+
+// MongoDeleteObservableGooglePlay returns found if the package name was observed and ok if no error occurred
+func MongoDeleteObservableGooglePlay(mongoClient *storageSession, packageName string) (found bool, ok bool) {
+	op := startStorageOperation(mongoClient, "delete_observable")
+	defer op.done()
+
+	err := mongoDatabase(mongoClient).
+		C(collectionObservableGooglePlay).
+		Remove(bson.M{"package_name": packageName})
+	if err == mgo.ErrNotFound {
+		return false, true
+	}
+	if err != nil {
+		op.fail(err)
+		return false, false
+	}
+
+	return true, true
+}
+
 // MongoGetAllObservableGooglePlay returns all observable apps
-func MongoGetAllObservableGooglePlay(mongoClient *mgo.Session) []ObservableGooglePlay {
+func MongoGetAllObservableGooglePlay(mongoClient *storageSession) []ObservableGooglePlay {
+	op := startStorageOperation(mongoClient, "get_all_observable")
+	defer op.done()
+
 	var observables []ObservableGooglePlay
-	err := mongoClient.
-		DB(database).
+	err := mongoDatabase(mongoClient).
 		C(collectionObservableGooglePlay).
 		Find(nil).
 		All(&observables)
 	if err != nil {
-		fmt.Println("ERR", err)
+		op.fail(err)
 		panic(err)
 	}
 
 	return observables
 }
 
-// MongoGeGooglePlayReviewOfClass returns all reviews belonging to the given package name and class
-func MongoGetGooglePlayReviewOfClass(mongoClient *mgo.Session, packageName string, reviewClass string) []AppReviewGooglePlay {
-	var reviewCLassField string
-	if reviewClass == "bug_report" {
-		reviewCLassField = "cluster_is_bug_report"
-	} else if reviewClass == "feature_request" {
-		reviewCLassField = "cluster_is_feature_request"
+// MongoGetGooglePlayReviewOfClass returns all reviews belonging to the given package name and class
+// whose label confidence is at least minConfidence, optionally without duplicates
+func MongoGetGooglePlayReviewOfClass(mongoClient *storageSession, packageName string, reviewClass string, minConfidence float64, excludeDuplicates bool) []AppReviewGooglePlay {
+	op := startStorageOperation(mongoClient, "get_review_of_class")
+	defer op.done()
+
+	query := reviewClassQuery(packageName, reviewClass, minConfidence)
+	if excludeDuplicates {
+		query["duplicate_of"] = bson.M{"$exists": false}
 	}
 	var reviews []AppReviewGooglePlay
-	err := mongoClient.
-		DB(database).
+	err := mongoDatabase(mongoClient).
 		C(collectionAppReviewsGooglePlay).
-		Find(bson.M{"package_name": packageName, reviewCLassField: true}).
+		Find(query).
 		All(&reviews)
 	if err != nil {
-		fmt.Println("ERR", err)
+		op.fail(err)
 		panic(err)
 	}
 
 	return reviews
 }
+
+// MongoCountGooglePlayReviewsOfClassBetween returns the number of reviews of the given package name and class
+// posted in [from, to)
+func MongoCountGooglePlayReviewsOfClassBetween(mongoClient *storageSession, packageName string, reviewClass string, from, to int64) int {
+	op := startStorageOperation(mongoClient, "count_reviews_of_class_between")
+	defer op.done()
+
+	query := reviewClassQuery(packageName, reviewClass, labelConfidenceThreshold)
+	query["date_posted"] = bson.M{"$gte": from, "$lt": to}
+	count, err := mongoDatabase(mongoClient).
+		C(collectionAppReviewsGooglePlay).
+		Find(query).
+		Count()
+	if err != nil {
+		op.fail(err)
+		return 0
+	}
+
+	return count
+}
+
+// MongoGetGooglePlayReviewRatingBetween returns the average rating and the number of reviews of the given package name
+// posted in [from, to)
+func MongoGetGooglePlayReviewRatingBetween(mongoClient *storageSession, packageName string, from, to int64) (float64, int) {
+	op := startStorageOperation(mongoClient, "get_review_rating_between")
+	defer op.done()
+
+	var result struct {
+		Average float64 `bson:"average"`
+		Count   int     `bson:"count"`
+	}
+	err := mongoDatabase(mongoClient).
+		C(collectionAppReviewsGooglePlay).
+		Pipe([]bson.M{
+			{"$match": bson.M{"package_name": packageName, "date_posted": bson.M{"$gte": from, "$lt": to}}},
+			{"$group": bson.M{"_id": nil, "average": bson.M{"$avg": "$rating"}, "count": bson.M{"$sum": 1}}},
+		}).
+		One(&result)
+	if err != nil && err != mgo.ErrNotFound {
+		op.fail(err)
+	}
+
+	return result.Average, result.Count
+}
+
+// MongoGetAppPagesGooglePlayBetween returns the app pages of the given package name crawled in [from, to), oldest first
+func MongoGetAppPagesGooglePlayBetween(mongoClient *storageSession, packageName string, from, to int64) []AppPageGooglePlay {
+	op := startStorageOperation(mongoClient, "get_app_pages_between")
+	defer op.done()
+
+	var appPages []AppPageGooglePlay
+	err := mongoDatabase(mongoClient).
+		C(collectionAppPageGooglePlay).
+		Find(bson.M{"package_name": packageName, "date_crawled": bson.M{"$gte": from, "$lt": to}}).
+		Sort("date_crawled").
+		All(&appPages)
+	if err != nil {
+		op.fail(err)
+	}
+
+	return appPages
+}
+
+// MongoFindAppReviewsGooglePlay returns a page of the reviews selected by the filter, newest first, and ok if no error
+// occurred
+func MongoFindAppReviewsGooglePlay(mongoClient *storageSession, filter appReviewFilter) ([]AppReviewGooglePlay, bool) {
+	op := startStorageOperation(mongoClient, "find_app_reviews")
+	defer op.done()
+
+	reviews := []AppReviewGooglePlay{}
+	err := mongoFilterAppReviews(mongoClient, filter).All(&reviews)
+	if err != nil {
+		op.fail(err)
+		return nil, false
+	}
+
+	return reviews, true
+}
+
+// MongoForEachMatchingAppReviewGooglePlay calls f for every review selected by the filter, newest first, without
+// loading all reviews into memory. A limit of 0 selects all reviews. It stops at the first error f returns.
+func MongoForEachMatchingAppReviewGooglePlay(mongoClient *storageSession, filter appReviewFilter, f func(AppReviewGooglePlay) error) error {
+	op := startStorageOperation(mongoClient, "for_each_matching_app_review")
+	defer op.done()
+
+	iter := mongoFilterAppReviews(mongoClient, filter).Iter()
+	var review AppReviewGooglePlay
+	for iter.Next(&review) {
+		if err := f(review); err != nil {
+			iter.Close()
+			return err
+		}
+		review = AppReviewGooglePlay{}
+	}
+
+	return op.check(iter.Close())
+}
+
+// mongoFilterAppReviews returns the query of the reviews selected by the filter, newest first
+func mongoFilterAppReviews(mongoClient *storageSession, filter appReviewFilter) *mgo.Query {
+	query := mongoDateRangeQuery(filter.packageName, "date_posted", filter.from, filter.to)
+	if filter.class != "" {
+		for field, value := range reviewClassQuery(filter.packageName, filter.class, filter.minConfidence) {
+			query[field] = value
+		}
+	}
+	if filter.excludeDuplicates {
+		query["duplicate_of"] = bson.M{"$exists": false}
+	}
+
+	return mongoDatabase(mongoClient).
+		C(collectionAppReviewsGooglePlay).
+		Find(query).
+		Sort("-date_posted", "review_id").
+		Skip(filter.offset).
+		Limit(filter.limit)
+}
+
+// MongoFindAppPagesGooglePlay returns a page of the app pages of the package name crawled in [from, to], newest first,
+// and ok if no error occurred
+func MongoFindAppPagesGooglePlay(mongoClient *storageSession, packageName string, from, to int64, offset, limit int) ([]AppPageGooglePlay, bool) {
+	op := startStorageOperation(mongoClient, "find_app_pages")
+	defer op.done()
+
+	appPages := []AppPageGooglePlay{}
+	err := mongoDatabase(mongoClient).
+		C(collectionAppPageGooglePlay).
+		Find(mongoDateRangeQuery(packageName, "date_crawled", from, to)).
+		Sort("-date_crawled").
+		Skip(offset).
+		Limit(limit).
+		All(&appPages)
+	if err != nil {
+		op.fail(err)
+		return nil, false
+	}
+
+	return appPages, true
+}
+
+// MongoInsertAlertRuleGooglePlay returns ok if the alert rule was inserted or updated
+func MongoInsertAlertRuleGooglePlay(mongoClient *storageSession, rule AlertRuleGooglePlay) bool {
+	op := startStorageOperation(mongoClient, "insert_alert_rule")
+	defer op.done()
+
+	_, err := mongoDatabase(mongoClient).
+		C(collectionAlertRuleGooglePlay).
+		Upsert(bson.M{"package_name": rule.PackageName, "type": rule.Type}, rule)
+	if err != nil {
+		op.fail(err)
+		return false
+	}
+
+	return true
+}
+
+// MongoGetAlertRulesGooglePlay returns the alert rules of the given package name or of all packages if it is empty
+func MongoGetAlertRulesGooglePlay(mongoClient *storageSession, packageName string) []AlertRuleGooglePlay {
+	op := startStorageOperation(mongoClient, "get_alert_rules")
+	defer op.done()
+
+	query := bson.M{}
+	if packageName != "" {
+		query["package_name"] = packageName
+	}
+	var rules []AlertRuleGooglePlay
+	err := mongoDatabase(mongoClient).
+		C(collectionAlertRuleGooglePlay).
+		Find(query).
+		All(&rules)
+	if err != nil {
+		op.fail(err)
+	}
+
+	return rules
+}
+
+// MongoDeleteAlertRuleGooglePlay returns ok if the alert rule was deleted or did not exist
+func MongoDeleteAlertRuleGooglePlay(mongoClient *storageSession, packageName, ruleType string) bool {
+	op := startStorageOperation(mongoClient, "delete_alert_rule")
+	defer op.done()
+
+	err := mongoDatabase(mongoClient).
+		C(collectionAlertRuleGooglePlay).
+		Remove(bson.M{"package_name": packageName, "type": ruleType})
+	if err != nil && err != mgo.ErrNotFound {
+		op.fail(err)
+		return false
+	}
+
+	return true
+}
+
+// MongoSetAlertRuleLastTriggered stores when the alert rule triggered the last time
+func MongoSetAlertRuleLastTriggered(mongoClient *storageSession, rule AlertRuleGooglePlay, triggeredAt int64) bool {
+	op := startStorageOperation(mongoClient, "set_alert_rule_last_triggered")
+	defer op.done()
+
+	err := mongoDatabase(mongoClient).
+		C(collectionAlertRuleGooglePlay).
+		Update(bson.M{"package_name": rule.PackageName, "type": rule.Type}, bson.M{"$set": bson.M{"last_triggered": triggeredAt}})
+	if err != nil {
+		op.fail(err)
+		return false
+	}
+
+	return true
+}
+
+// MongoInsertAlertGooglePlay returns ok if the alert was inserted
+func MongoInsertAlertGooglePlay(mongoClient *storageSession, alert AlertGooglePlay) bool {
+	op := startStorageOperation(mongoClient, "insert_alert")
+	defer op.done()
+
+	err := mongoDatabase(mongoClient).C(collectionAlertGooglePlay).Insert(alert)
+	if err != nil {
+		op.fail(err)
+		return false
+	}
+
+	return true
+}
+
+// MongoGetAlertsGooglePlay returns the alerts of the given package name, latest first
+func MongoGetAlertsGooglePlay(mongoClient *storageSession, packageName string) []AlertGooglePlay {
+	op := startStorageOperation(mongoClient, "get_alerts")
+	defer op.done()
+
+	var alerts []AlertGooglePlay
+	err := mongoDatabase(mongoClient).
+		C(collectionAlertGooglePlay).
+		Find(bson.M{"package_name": packageName}).
+		Sort("-triggered_at").
+		All(&alerts)
+	if err != nil {
+		op.fail(err)
+	}
+
+	return alerts
+}
+
+// MongoInsertSubscriptionGooglePlay returns ok if the subscription was inserted
+func MongoInsertSubscriptionGooglePlay(mongoClient *storageSession, subscription SubscriptionGooglePlay) bool {
+	op := startStorageOperation(mongoClient, "insert_subscription")
+	defer op.done()
+
+	err := mongoDatabase(mongoClient).C(collectionSubscriptionGooglePlay).Insert(subscription)
+	if err != nil {
+		op.fail(err)
+		return false
+	}
+
+	return true
+}
+
+// MongoGetAllSubscriptionGooglePlay returns all webhook subscriptions including their secrets
+func MongoGetAllSubscriptionGooglePlay(mongoClient *storageSession) []SubscriptionGooglePlay {
+	op := startStorageOperation(mongoClient, "get_all_subscription")
+	defer op.done()
+
+	var subscriptions []SubscriptionGooglePlay
+	err := mongoDatabase(mongoClient).
+		C(collectionSubscriptionGooglePlay).
+		Find(nil).
+		All(&subscriptions)
+	if err != nil {
+		op.fail(err)
+	}
+
+	return subscriptions
+}
+
+// MongoDeleteSubscriptionGooglePlay returns found if the subscription existed and ok if no error occurred
+func MongoDeleteSubscriptionGooglePlay(mongoClient *storageSession, subscriptionID string) (found bool, ok bool) {
+	op := startStorageOperation(mongoClient, "delete_subscription")
+	defer op.done()
+
+	err := mongoDatabase(mongoClient).
+		C(collectionSubscriptionGooglePlay).
+		Remove(bson.M{"subscription_id": subscriptionID})
+	if err == mgo.ErrNotFound {
+		return false, true
+	}
+	if err != nil {
+		op.fail(err)
+		return false, false
+	}
+
+	return true, true
+}
+
+// MongoInsertWebhookDeliveryGooglePlay returns ok if the delivery log entry was inserted
+func MongoInsertWebhookDeliveryGooglePlay(mongoClient *storageSession, delivery WebhookDeliveryGooglePlay) bool {
+	op := startStorageOperation(mongoClient, "insert_webhook_delivery")
+	defer op.done()
+
+	err := mongoDatabase(mongoClient).C(collectionWebhookDeliveryGooglePlay).Insert(delivery)
+	if err != nil {
+		op.fail(err)
+		return false
+	}
+
+	return true
+}
+
+// MongoGetWebhookDeliveriesGooglePlay returns the delivery log of a subscription, latest first
+func MongoGetWebhookDeliveriesGooglePlay(mongoClient *storageSession, subscriptionID string) []WebhookDeliveryGooglePlay {
+	op := startStorageOperation(mongoClient, "get_webhook_deliveries")
+	defer op.done()
+
+	var deliveries []WebhookDeliveryGooglePlay
+	err := mongoDatabase(mongoClient).
+		C(collectionWebhookDeliveryGooglePlay).
+		Find(bson.M{"subscription_id": subscriptionID}).
+		Sort("-created_at").
+		All(&deliveries)
+	if err != nil {
+		op.fail(err)
+	}
+
+	return deliveries
+}
+
+// mongoNextSequence returns the next value of the named counter
+func mongoNextSequence(mongoClient *storageSession, name string) (int64, error) {
+	var counter struct {
+		Sequence int64 `bson:"sequence"`
+	}
+	change := mgo.Change{
+		Update:    bson.M{"$inc": bson.M{"sequence": 1}},
+		Upsert:    true,
+		ReturnNew: true,
+	}
+	_, err := mongoDatabase(mongoClient).
+		C(collectionCounter).
+		FindId(name).
+		Apply(change, &counter)
+
+	return counter.Sequence, err
+}
+
+// mongoWriteOutboxEvent records a write in the outbox so that it can be published to other systems
+func mongoWriteOutboxEvent(mongoClient *storageSession, eventType, key string, payload interface{}) {
+	sequence, err := mongoNextSequence(mongoClient, collectionOutbox)
+	if err != nil {
+		loggerFrom(mongoClient.ctx).Error("could not write outbox event", "type", eventType, "key", key, "error", err)
+		return
+	}
+
+	err = mongoDatabase(mongoClient).C(collectionOutbox).Insert(OutboxEvent{
+		Sequence:  sequence,
+		Tenant:    tenantFrom(mongoClient.ctx),
+		Type:      eventType,
+		Key:       key,
+		Payload:   payload,
+		CreatedAt: time.Now().Unix(),
+	})
+	if err != nil {
+		loggerFrom(mongoClient.ctx).Error("could not write outbox event", "type", eventType, "key", key, "error", err)
+	}
+}
+
+// MongoGetOutboxEventsAfter returns at most limit outbox events following the given sequence number, oldest first
+func MongoGetOutboxEventsAfter(mongoClient *storageSession, sequence int64, limit int) []OutboxEvent {
+	op := startStorageOperation(mongoClient, "get_outbox_events_after")
+	defer op.done()
+
+	var events []OutboxEvent
+	err := mongoDatabase(mongoClient).
+		C(collectionOutbox).
+		Find(bson.M{"sequence": bson.M{"$gt": sequence}}).
+		Sort("sequence").
+		Limit(limit).
+		All(&events)
+	if err != nil {
+		op.fail(err)
+	}
+
+	return events
+}
+
+// MongoGetOutboxOffset returns the sequence number of the last event the named publisher delivered
+func MongoGetOutboxOffset(mongoClient *storageSession, publisher string) int64 {
+	op := startStorageOperation(mongoClient, "get_outbox_offset")
+	defer op.done()
+
+	var offset struct {
+		Sequence int64 `bson:"sequence"`
+	}
+	err := mongoDatabase(mongoClient).
+		C(collectionOutboxOffset).
+		FindId(publisher).
+		One(&offset)
+	if err != nil && err != mgo.ErrNotFound {
+		op.fail(err)
+	}
+
+	return offset.Sequence
+}
+
+// MongoSetOutboxOffset returns ok if the offset of the named publisher was stored
+func MongoSetOutboxOffset(mongoClient *storageSession, publisher string, sequence int64) bool {
+	op := startStorageOperation(mongoClient, "set_outbox_offset")
+	defer op.done()
+
+	_, err := mongoDatabase(mongoClient).
+		C(collectionOutboxOffset).
+		UpsertId(publisher, bson.M{"$set": bson.M{"sequence": sequence, "updated_at": time.Now().Unix()}})
+	if err != nil {
+		op.fail(err)
+		return false
+	}
+
+	return true
+}
+
+// mongoDateRangeQuery returns the query selecting the documents of a package (or all packages if it is empty)
+// whose date field lies in [from, to], to is ignored if it is 0
+func mongoDateRangeQuery(packageName, dateField string, from, to int64) bson.M {
+	query := bson.M{}
+	if packageName != "" {
+		query["package_name"] = packageName
+	}
+	dateRange := bson.M{"$gte": from}
+	if to != 0 {
+		dateRange["$lte"] = to
+	}
+	query[dateField] = dateRange
+
+	return query
+}
+
+// MongoForEachAppReviewGooglePlay calls f for every review of the package posted in [from, to] without loading all
+// reviews into memory. It stops at the first error f returns.
+func MongoForEachAppReviewGooglePlay(mongoClient *storageSession, packageName string, from, to int64, f func(AppReviewGooglePlay) error) error {
+	op := startStorageOperation(mongoClient, "for_each_app_review")
+	defer op.done()
+
+	iter := mongoDatabase(mongoClient).
+		C(collectionAppReviewsGooglePlay).
+		Find(mongoDateRangeQuery(packageName, "date_posted", from, to)).
+		Sort("date_posted").
+		Iter()
+
+	var review AppReviewGooglePlay
+	for iter.Next(&review) {
+		if err := f(review); err != nil {
+			iter.Close()
+			return err
+		}
+		review = AppReviewGooglePlay{}
+	}
+
+	return op.check(iter.Close())
+}
+
+// MongoForEachAppPageGooglePlay calls f for every app page of the package crawled in [from, to] without loading all
+// app pages into memory. It stops at the first error f returns.
+func MongoForEachAppPageGooglePlay(mongoClient *storageSession, packageName string, from, to int64, f func(AppPageGooglePlay) error) error {
+	op := startStorageOperation(mongoClient, "for_each_app_page")
+	defer op.done()
+
+	iter := mongoDatabase(mongoClient).
+		C(collectionAppPageGooglePlay).
+		Find(mongoDateRangeQuery(packageName, "date_crawled", from, to)).
+		Sort("date_crawled").
+		Iter()
+
+	var appPage AppPageGooglePlay
+	for iter.Next(&appPage) {
+		if err := f(appPage); err != nil {
+			iter.Close()
+			return err
+		}
+		appPage = AppPageGooglePlay{}
+	}
+
+	return op.check(iter.Close())
+}
+
+// MongoBulkUpsertAppReviewGooglePlay inserts or updates a batch of reviews at once and returns the stored reviews
+// and those of them that were new. Within the batch the last review with a review id wins.
+func MongoBulkUpsertAppReviewGooglePlay(mongoClient *storageSession, reviews []AppReviewGooglePlay) (stored, newReviews []AppReviewGooglePlay, ok bool) {
+	op := startStorageOperation(mongoClient, "bulk_upsert_app_review")
+	defer op.done()
+
+	if len(reviews) == 0 {
+		return nil, nil, true
+	}
+	col := mongoDatabase(mongoClient).C(collectionAppReviewsGooglePlay)
+
+	latest := map[string]int{}
+	for i, review := range reviews {
+		latest[review.ReviewID] = i
+	}
+	reviewIDs := make([]string, 0, len(latest))
+	for reviewID := range latest {
+		reviewIDs = append(reviewIDs, reviewID)
+	}
+	var existing []AppReviewGooglePlay
+	err := col.Find(bson.M{"review_id": bson.M{"$in": reviewIDs}}).Select(bson.M{"review_id": 1}).All(&existing)
+	if err != nil {
+		op.fail(err)
+		return nil, nil, false
+	}
+	exists := map[string]bool{}
+	for _, review := range existing {
+		exists[review.ReviewID] = true
+	}
+
+	bulk := col.Bulk()
+	bulk.Unordered()
+	for i, review := range reviews {
+		if latest[review.ReviewID] != i {
+			continue
+		}
+		review = fingerprintReview(syncReviewLabels(review))
+		review.DuplicateOf, review.DuplicateType = mongoFindDuplicateOf(mongoClient, col, review)
+		review.StoredAt = time.Now()
+		bulk.Upsert(bson.M{"review_id": review.ReviewID}, review)
+		stored = append(stored, review)
+	}
+	if _, err = bulk.Run(); err != nil {
+		op.fail(err)
+		return nil, nil, false
+	}
+
+	for _, review := range stored {
+		if exists[review.ReviewID] {
+			mongoWriteOutboxEvent(mongoClient, outboxEventAppReviewUpdated, review.ReviewID, review)
+		} else {
+			newReviews = append(newReviews, review)
+			reviewsIngested.add(1, review.PackageName)
+			mongoWriteOutboxEvent(mongoClient, outboxEventAppReviewInserted, review.ReviewID, review)
+		}
+	}
+
+	return stored, newReviews, true
+}
+
+// MongoBulkInsertAppPageGooglePlay inserts a batch of app pages at once and returns the new app pages
+// and how many already existed
+func MongoBulkInsertAppPageGooglePlay(mongoClient *storageSession, appPages []AppPageGooglePlay) (newAppPages []AppPageGooglePlay, existing int, ok bool) {
+	op := startStorageOperation(mongoClient, "bulk_insert_app_page")
+	defer op.done()
+
+	if len(appPages) == 0 {
+		return nil, 0, true
+	}
+	col := mongoDatabase(mongoClient).C(collectionAppPageGooglePlay)
+
+	keys := make([]bson.M, len(appPages))
+	for i, appPage := range appPages {
+		keys[i] = bson.M{"package_name": appPage.PackageName, "last_update": appPage.LastUpdate}
+	}
+	var stored []AppPageGooglePlay
+	err := col.Find(bson.M{"$or": keys}).Select(bson.M{"package_name": 1, "last_update": 1}).All(&stored)
+	if err != nil {
+		op.fail(err)
+		return nil, 0, false
+	}
+	exists := map[string]bool{}
+	for _, appPage := range stored {
+		exists[fmt.Sprintf("%s/%d", appPage.PackageName, appPage.LastUpdate)] = true
+	}
+
+	bulk := col.Bulk()
+	bulk.Unordered()
+	for _, appPage := range appPages {
+		key := fmt.Sprintf("%s/%d", appPage.PackageName, appPage.LastUpdate)
+		if exists[key] {
+			existing++
+			continue
+		}
+		exists[key] = true
+		appPage.StoredAt = time.Now()
+		bulk.Insert(appPage)
+		newAppPages = append(newAppPages, appPage)
+	}
+	if existing > 0 {
+		storageDuplicateKeys.add(float64(existing), collectionAppPageGooglePlay)
+	}
+	if len(newAppPages) == 0 {
+		return nil, existing, true
+	}
+	if _, err = bulk.Run(); err != nil && !mgo.IsDup(err) {
+		op.fail(err)
+		return nil, existing, false
+	}
+
+	for _, appPage := range newAppPages {
+		mongoWriteOutboxEvent(mongoClient, outboxEventAppPageInserted, appPage.PackageName, appPage)
+	}
+
+	return newAppPages, existing, true
+}
+
+// MongoGetStatisticsGooglePlay returns the number of stored documents of the given package name or of all packages
+// if it is empty
+func MongoGetStatisticsGooglePlay(mongoClient *storageSession, packageName string) (StatisticsGooglePlay, error) {
+	op := startStorageOperation(mongoClient, "get_statistics")
+	defer op.done()
+
+	statistics := StatisticsGooglePlay{PackageName: packageName}
+	packageQuery := func(query bson.M) bson.M {
+		if packageName != "" {
+			query["package_name"] = packageName
+		}
+		return query
+	}
+
+	counts := []struct {
+		collection string
+		query      bson.M
+		count      *int
+	}{
+		{collectionAppReviewsGooglePlay, packageQuery(bson.M{}), &statistics.AppReviews},
+		{collectionAppReviewsGooglePlay, packageQuery(bson.M{"cluster_is_bug_report": true}), &statistics.BugReports},
+		{collectionAppReviewsGooglePlay, packageQuery(bson.M{"cluster_is_feature_request": true}), &statistics.FeatureRequests},
+		{collectionAppReviewsGooglePlay, packageQuery(bson.M{"duplicate_of": bson.M{"$exists": true}}), &statistics.Duplicates},
+		{collectionAppPageGooglePlay, packageQuery(bson.M{}), &statistics.AppPages},
+		{collectionObservableGooglePlay, packageQuery(bson.M{}), &statistics.Observables},
+	}
+	for _, c := range counts {
+		count, err := mongoDatabase(mongoClient).C(c.collection).Find(c.query).Count()
+		if err != nil {
+			return statistics, op.check(err)
+		}
+		*c.count = count
+	}
+
+	return statistics, nil
+}
+
+// MongoMigrateAppReviewGooglePlay adds labels, fingerprints and duplicate flags to the reviews stored before these
+// existed and returns the number of migrated reviews
+func MongoMigrateAppReviewGooglePlay(mongoClient *storageSession) (int, error) {
+	op := startStorageOperation(mongoClient, "migrate_app_review")
+	defer op.done()
+
+	col := mongoDatabase(mongoClient).C(collectionAppReviewsGooglePlay)
+	iter := col.Find(bson.M{"fingerprint": bson.M{"$exists": false}}).Sort("date_posted").Iter()
+
+	migrated := 0
+	var review AppReviewGooglePlay
+	for iter.Next(&review) {
+		review = fingerprintReview(syncReviewLabels(review))
+		review.DuplicateOf, review.DuplicateType = mongoFindDuplicateOf(mongoClient, col, review)
+		if review.Fingerprint == "" && len(review.Labels) == 0 {
+			review = AppReviewGooglePlay{}
+			continue
+		}
+
+		set := bson.M{
+			"labels":        review.Labels,
+			"fingerprint":   review.Fingerprint,
+			"minhash":       review.MinHash,
+			"minhash_bands": review.MinHashBands,
+		}
+		if review.DuplicateOf != "" {
+			set["duplicate_of"] = review.DuplicateOf
+			set["duplicate_type"] = review.DuplicateType
+		}
+		err := col.Update(bson.M{"review_id": review.ReviewID}, bson.M{"$set": set})
+		if err != nil {
+			iter.Close()
+			return migrated, op.check(err)
+		}
+		migrated++
+		review = AppReviewGooglePlay{}
+	}
+
+	return migrated, op.check(iter.Close())
+}
+
+// MongoBackfillStoredAt sets the stored_at date of the app reviews and app pages stored before it existed to the
+// given time, so that retention counts their max age from the migration on. It returns the number of updated documents.
+func MongoBackfillStoredAt(mongoClient *storageSession, now time.Time) (int, error) {
+	op := startStorageOperation(mongoClient, "backfill_stored_at")
+	defer op.done()
+
+	backfilled := 0
+	for _, collection := range []string{collectionAppReviewsGooglePlay, collectionAppPageGooglePlay} {
+		info, err := mongoDatabase(mongoClient).C(collection).UpdateAll(
+			bson.M{"stored_at": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"stored_at": now}},
+		)
+		if err != nil {
+			return backfilled, op.check(err)
+		}
+		backfilled += info.Updated
+	}
+
+	return backfilled, nil
+}
+
+// MongoRemoveDocumentsBefore removes the documents of the collection whose field lies before the given unix time or
+// time.Time and returns the number of removed documents and ok if no error occurred
+func MongoRemoveDocumentsBefore(mongoClient *storageSession, collection, field string, before interface{}) (int, bool) {
+	op := startStorageOperation(mongoClient, "remove_documents_before")
+	defer op.done()
+
+	info, err := mongoDatabase(mongoClient).C(collection).RemoveAll(bson.M{field: bson.M{"$lt": before}})
+	if err != nil {
+		op.fail(err)
+		return 0, false
+	}
+
+	return info.Removed, true
+}
+
+// MongoEraseAuthorGooglePlay deletes the reviews stored under one of the author names or removes the author from
+// them if mode is redact. The author is removed from the review payloads of the outbox as well. It returns the
+// number of erased reviews and redacted outbox events and ok if no error occurred.
+func MongoEraseAuthorGooglePlay(mongoClient *storageSession, authors []string, mode string) (reviews int, outboxEvents int, ok bool) {
+	op := startStorageOperation(mongoClient, "erase_author")
+	defer op.done()
+
+	db := mongoDatabase(mongoClient)
+	selector := bson.M{"author": bson.M{"$in": authors}}
+	if mode == erasureRedact {
+		info, err := db.C(collectionAppReviewsGooglePlay).UpdateAll(selector, bson.M{"$set": bson.M{"author": ""}})
+		if err != nil {
+			op.fail(err)
+			return 0, 0, false
+		}
+		reviews = info.Updated
+	} else {
+		info, err := db.C(collectionAppReviewsGooglePlay).RemoveAll(selector)
+		if err != nil {
+			op.fail(err)
+			return 0, 0, false
+		}
+		reviews = info.Removed
+	}
+
+	info, err := db.C(collectionOutbox).UpdateAll(
+		bson.M{"payload.author": bson.M{"$in": authors}},
+		bson.M{"$set": bson.M{"payload.author": ""}},
+	)
+	if err != nil {
+		op.fail(err)
+		return reviews, 0, false
+	}
+
+	return reviews, info.Updated, true
+}
+
+// MongoInsertErasureGooglePlay returns ok if the erasure record was stored
+func MongoInsertErasureGooglePlay(mongoClient *storageSession, erasure ErasureGooglePlay) bool {
+	op := startStorageOperation(mongoClient, "insert_erasure")
+	defer op.done()
+
+	err := mongoDatabase(mongoClient).C(collectionErasure).Insert(erasure)
+	if err != nil {
+		op.fail(err)
+		return false
+	}
+
+	return true
+}
+
+// MongoGetErasuresGooglePlay returns a page of the erasure records, newest first, and ok if no error occurred
+func MongoGetErasuresGooglePlay(mongoClient *storageSession, offset, limit int) ([]ErasureGooglePlay, bool) {
+	op := startStorageOperation(mongoClient, "get_erasures")
+	defer op.done()
+
+	erasures := []ErasureGooglePlay{}
+	err := mongoDatabase(mongoClient).
+		C(collectionErasure).
+		Find(nil).
+		Sort("-erased_at", "erasure_id").
+		Skip(offset).
+		Limit(limit).
+		All(&erasures)
+	if err != nil {
+		op.fail(err)
+		return nil, false
+	}
+
+	return erasures, true
+}
+
+// MongoInsertAuditEntryGooglePlay returns ok if the audit entry was stored
+func MongoInsertAuditEntryGooglePlay(mongoClient *storageSession, entry AuditEntryGooglePlay) bool {
+	op := startStorageOperation(mongoClient, "insert_audit_entry")
+	defer op.done()
+
+	err := mongoDatabase(mongoClient).C(collectionAudit).Insert(entry)
+	if err != nil {
+		op.fail(err)
+		return false
+	}
+
+	return true
+}
+
+// MongoFindAuditEntriesGooglePlay returns a page of the audit entries matching the filter, newest first, and ok if no
+// error occurred
+func MongoFindAuditEntriesGooglePlay(mongoClient *storageSession, filter auditFilter) ([]AuditEntryGooglePlay, bool) {
+	op := startStorageOperation(mongoClient, "find_audit_entries")
+	defer op.done()
+
+	selector := mongoDateRangeQuery("", "timestamp", filter.from, filter.to)
+	if filter.action != "" {
+		selector["action"] = filter.action
+	}
+	if filter.client != "" {
+		selector["client"] = filter.client
+	}
+	if filter.key != "" {
+		selector["keys"] = filter.key
+	}
+
+	entries := []AuditEntryGooglePlay{}
+	err := mongoDatabase(mongoClient).
+		C(collectionAudit).
+		Find(selector).
+		Sort("-timestamp", "-_id").
+		Skip(filter.offset).
+		Limit(filter.limit).
+		All(&entries)
+	if err != nil {
+		op.fail(err)
+		return nil, false
+	}
+
+	return entries, true
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+const openAPIVersion = "3.0.3"
+
+var pathParameterPattern = regexp.MustCompile(`{([^}]+)}`)
+
+// openAPIDocument returns the OpenAPI 3 document of the routes. The schemas of the models are derived from their json
+// tags.
+func openAPIDocument(routes []apiRoute) map[string]interface{} {
+	schemas := map[string]interface{}{}
+	paths := map[string]interface{}{}
+	for _, route := range routes {
+		path := v2Prefix + route.path
+		operations, ok := paths[path].(map[string]interface{})
+		if !ok {
+			operations = map[string]interface{}{}
+			paths[path] = operations
+		}
+		operations[strings.ToLower(route.method)] = openAPIOperation(route, schemas)
+	}
+
+	return map[string]interface{}{
+		"openapi": openAPIVersion,
+		"info": map[string]interface{}{
+			"title":       defaultServiceName,
+			"description": "Stores the app pages and reviews crawled from the Google Play Store.",
+			"version":     version,
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": schemas,
+			"securitySchemes": map[string]interface{}{
+				"apiKey": map[string]interface{}{"type": "apiKey", "in": "header", "name": apiKeyHeader},
+				"bearer": map[string]interface{}{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+			},
+		},
+		"security": []interface{}{
+			map[string]interface{}{"apiKey": []string{}},
+			map[string]interface{}{"bearer": []string{}},
+		},
+	}
+}
+
+func openAPIOperation(route apiRoute, schemas map[string]interface{}) map[string]interface{} {
+	parameters := []interface{}{}
+	for _, match := range pathParameterPattern.FindAllStringSubmatch(route.path, -1) {
+		parameters = append(parameters, map[string]interface{}{
+			"name":     match[1],
+			"in":       "path",
+			"required": true,
+			"schema":   map[string]interface{}{"type": "string"},
+		})
+	}
+	for _, parameter := range route.query {
+		parameters = append(parameters, map[string]interface{}{
+			"name":        parameter.name,
+			"in":          "query",
+			"description": parameter.description,
+			"schema":      map[string]interface{}{"type": parameter.kind},
+		})
+	}
+
+	envelope := openAPIContent(schemaOf(reflect.TypeOf(ResponseRecentData{}), schemas))
+	success := map[string]interface{}{"description": "the request succeeded.", "content": envelope}
+	if route.response != nil {
+		success["description"] = "the request succeeded, the data is answered without the envelope."
+		success["content"] = openAPIContent(schemaOf(reflect.TypeOf(route.response), schemas))
+	}
+	operation := map[string]interface{}{
+		"operationId": route.operationID,
+		"summary":     route.summary,
+		"parameters":  parameters,
+		"responses": map[string]interface{}{
+			"200":     success,
+			"default": map[string]interface{}{"description": "the request failed, the code of the envelope tells why.", "content": envelope},
+		},
+		"x-roles": append([]string{roleAdmin}, route.roles...),
+	}
+	if route.request != nil {
+		operation["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content":  openAPIContent(schemaOf(reflect.TypeOf(route.request), schemas)),
+		}
+	}
+
+	return operation
+}
+
+func openAPIContent(schema map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{"application/json": map[string]interface{}{"schema": schema}}
+}
+
+// schemaOf returns the schema of a type. Named structs are added to the schemas and referenced, so that models used by
+// several routes are described once.
+func schemaOf(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Int64, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Ptr:
+		return schemaOf(t.Elem(), schemas)
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": schemaOf(t.Elem(), schemas)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaOf(t.Elem(), schemas)}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return structSchema(t, schemas)
+		}
+		if _, ok := schemas[t.Name()]; !ok {
+			schemas[t.Name()] = map[string]interface{}{}
+			schemas[t.Name()] = structSchema(t, schemas)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+	default:
+		// interface values can hold anything
+		return map[string]interface{}{}
+	}
+}
+
+// structSchema describes the exported fields of a struct by their json names, omitting the fields hidden from json
+func structSchema(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	properties := map[string]interface{}{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = schemaOf(field.Type, schemas)
+	}
+
+	return map[string]interface{}{"type": "object", "properties": properties}
+}
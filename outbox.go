@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	mgo "gopkg.in/mgo.v2"
+)
+
+const (
+	outboxEventAppReviewInserted  = "app_review.inserted"
+	outboxEventAppReviewUpdated   = "app_review.updated"
+	outboxEventAppPageInserted    = "app_page.inserted"
+	outboxEventObservableInserted = "observable.inserted"
+	outboxEventObservableUpdated  = "observable.updated"
+	outboxEventAuthorErased       = "author.erased"
+
+	outboxBatchSize           = 100
+	defaultOutboxPollInterval = 5 * time.Second
+
+	// outboxGapTimeout is how long the relay waits for a missing sequence number before skipping it.
+	// Gaps appear while a concurrent write is still inserting its event or when that insert failed.
+	outboxGapTimeout = int64(30)
+)
+
+// EventPublisher delivers outbox events to another system
+type EventPublisher interface {
+	// Name identifies the publisher, its offset is stored under this name
+	Name() string
+	Publish(event OutboxEvent) error
+	Close() error
+}
+
+// newEventPublisher creates the publisher for a uri of the form file:///path/events.jsonl or nats://host:4222/subject
+func newEventPublisher(uri string) (EventPublisher, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "file":
+		return newFilePublisher(u.Path)
+	case "nats":
+		subject := strings.Trim(u.Path, "/")
+		if subject == "" {
+			subject = "ri-storage-app"
+		}
+		return newNatsPublisher(u.Host, subject)
+	}
+
+	return nil, fmt.Errorf("unknown event publisher %q", uri)
+}
+
+// runOutboxRelay publishes new outbox events periodically until stop is closed
+func runOutboxRelay(mongoClient *mgo.Session, publisher EventPublisher, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		forEachTenant(mongoClient, func(m *storageSession) {
+			relayOutboxEvents(m, publisher, time.Now().Unix())
+		})
+	}
+}
+
+// relayOutboxEvents publishes the outbox events following the stored offset of the publisher and advances the offset
+// after every delivered event, so that every event is delivered at least once. It returns the number of published events.
+func relayOutboxEvents(mongoClient *storageSession, publisher EventPublisher, now int64) int {
+	offset := MongoGetOutboxOffset(mongoClient, publisher.Name())
+	published := 0
+	for {
+		events := MongoGetOutboxEventsAfter(mongoClient, offset, outboxBatchSize)
+		for _, event := range events {
+			if event.Sequence != offset+1 && now-event.CreatedAt < outboxGapTimeout {
+				return published
+			}
+			event.Tenant = tenantFrom(mongoClient.ctx)
+			event.ID = outboxEventID(event.Tenant, event.Sequence)
+			err := publisher.Publish(event)
+			if err != nil {
+				loggerFrom(mongoClient.ctx).Error("could not publish outbox event", "sequence", event.Sequence, "publisher", publisher.Name(), "error", err)
+				return published
+			}
+			offset = event.Sequence
+			published++
+			MongoSetOutboxOffset(mongoClient, publisher.Name(), offset)
+		}
+		if len(events) < outboxBatchSize {
+			return published
+		}
+	}
+}
+
+// outboxEventID returns the id of an event, its tenant and sequence number, so that the events of different tenants
+// published to the same publisher can be told apart. The default tenant has an empty name.
+func outboxEventID(tenant string, sequence int64) string {
+	return fmt.Sprintf("%s:%d", tenant, sequence)
+}
+
+// filePublisher appends events as json lines to a file
+type filePublisher struct {
+	path string
+	file *os.File
+}
+
+func newFilePublisher(path string) (*filePublisher, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &filePublisher{path: path, file: file}, nil
+}
+
+func (p *filePublisher) Name() string {
+	return "file:" + p.path
+}
+
+func (p *filePublisher) Publish(event OutboxEvent) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if _, err = p.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+
+	return p.file.Sync()
+}
+
+func (p *filePublisher) Close() error {
+	return p.file.Close()
+}
+
+// natsPublisher publishes events to a subject of a NATS server using the plain text protocol.
+// Every publish is confirmed with a PING/PONG round trip before the offset advances.
+type natsPublisher struct {
+	mu      sync.Mutex
+	address string
+	subject string
+	conn    net.Conn
+	reader  *bufio.Reader
+}
+
+func newNatsPublisher(address, subject string) (*natsPublisher, error) {
+	p := &natsPublisher{address: address, subject: subject}
+	if err := p.connect(); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func (p *natsPublisher) connect() error {
+	conn, err := net.DialTimeout("tcp", p.address, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	reader := bufio.NewReader(conn)
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	info, err := reader.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	if !strings.HasPrefix(info, "INFO") {
+		conn.Close()
+		return fmt.Errorf("unexpected NATS greeting %q", strings.TrimSpace(info))
+	}
+	if _, err = fmt.Fprint(conn, "CONNECT {\"verbose\":false,\"pedantic\":false,\"name\":\"ri-storage-app\"}\r\n"); err != nil {
+		conn.Close()
+		return err
+	}
+
+	p.conn, p.reader = conn, reader
+	return nil
+}
+
+func (p *natsPublisher) Name() string {
+	return "nats:" + p.address + "/" + p.subject
+}
+
+func (p *natsPublisher) Publish(event OutboxEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conn == nil {
+		if err = p.connect(); err != nil {
+			return err
+		}
+	}
+	err = p.publish(payload)
+	if err != nil {
+		p.conn.Close()
+		p.conn = nil
+	}
+
+	return err
+}
+
+func (p *natsPublisher) publish(payload []byte) error {
+	p.conn.SetDeadline(time.Now().Add(10 * time.Second))
+	if _, err := fmt.Fprintf(p.conn, "PUB %s %d\r\n%s\r\nPING\r\n", p.subject, len(payload), payload); err != nil {
+		return err
+	}
+	for {
+		line, err := p.reader.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		switch {
+		case strings.HasPrefix(line, "PONG"):
+			return nil
+		case strings.HasPrefix(line, "PING"):
+			fmt.Fprint(p.conn, "PONG\r\n")
+		case strings.HasPrefix(line, "-ERR"):
+			return fmt.Errorf("NATS error: %s", strings.TrimSpace(line))
+		}
+	}
+}
+
+func (p *natsPublisher) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conn == nil {
+		return nil
+	}
+
+	return p.conn.Close()
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// The models are encoded in the protobuf wire format by their proto tags, which hold the field numbers of the
+// messages in ristorage.proto. Strings, booleans, integers, doubles, nested models and repeated fields of them are
+// supported, like the proto3 types string, bool, int32, int64, double and message.
+
+// protobuf wire types
+const (
+	protoVarint  = 0
+	protoFixed64 = 1
+	protoBytes   = 2
+	protoFixed32 = 5
+)
+
+var errProtoTruncated = errors.New("truncated protobuf message")
+
+// protoField is a field of a model with its field number
+type protoField struct {
+	number int
+	index  int
+}
+
+// protoFields caches the fields of the models with proto tags, ordered by their field numbers
+var protoFields sync.Map
+
+func protoFieldsOf(t reflect.Type) []protoField {
+	if fields, ok := protoFields.Load(t); ok {
+		return fields.([]protoField)
+	}
+	var fields []protoField
+	for i := 0; i < t.NumField(); i++ {
+		if number, err := strconv.Atoi(t.Field(i).Tag.Get("proto")); err == nil {
+			fields = append(fields, protoField{number: number, index: i})
+		}
+	}
+	sort.Slice(fields, func(i, j int) bool { return fields[i].number < fields[j].number })
+	protoFields.Store(t, fields)
+
+	return fields
+}
+
+// marshalProto encodes a model, fields holding their zero value are left out like in proto3
+func marshalProto(v interface{}) []byte {
+	return appendProtoMessage(nil, reflect.Indirect(reflect.ValueOf(v)))
+}
+
+func appendProtoMessage(buf []byte, v reflect.Value) []byte {
+	for _, field := range protoFieldsOf(v.Type()) {
+		buf = appendProtoField(buf, field.number, v.Field(field.index), false)
+	}
+
+	return buf
+}
+
+// appendProtoField appends the field with its key, elements of repeated fields are appended even if they are empty
+func appendProtoField(buf []byte, number int, f reflect.Value, element bool) []byte {
+	key := func(wireType int) []byte {
+		return binary.AppendUvarint(buf, uint64(number)<<3|uint64(wireType))
+	}
+	switch f.Kind() {
+	case reflect.String:
+		if f.Len() > 0 || element {
+			buf = binary.AppendUvarint(key(protoBytes), uint64(f.Len()))
+			buf = append(buf, f.String()...)
+		}
+	case reflect.Bool:
+		if f.Bool() || element {
+			value := uint64(0)
+			if f.Bool() {
+				value = 1
+			}
+			buf = binary.AppendUvarint(key(protoVarint), value)
+		}
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		if f.Int() != 0 || element {
+			buf = binary.AppendUvarint(key(protoVarint), uint64(f.Int()))
+		}
+	case reflect.Float64:
+		if f.Float() != 0 || element {
+			buf = binary.LittleEndian.AppendUint64(key(protoFixed64), math.Float64bits(f.Float()))
+		}
+	case reflect.Struct:
+		message := appendProtoMessage(nil, f)
+		buf = binary.AppendUvarint(key(protoBytes), uint64(len(message)))
+		buf = append(buf, message...)
+	case reflect.Slice:
+		for i := 0; i < f.Len(); i++ {
+			buf = appendProtoField(buf, number, f.Index(i), true)
+		}
+	}
+
+	return buf
+}
+
+// unmarshalProto decodes a message into the model v points to, unknown fields are skipped
+func unmarshalProto(data []byte, v interface{}) error {
+	return decodeProtoMessage(data, reflect.ValueOf(v).Elem())
+}
+
+func decodeProtoMessage(data []byte, v reflect.Value) error {
+	fields := protoFieldsOf(v.Type())
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return errProtoTruncated
+		}
+		data = data[n:]
+		number, wireType := int(key>>3), int(key&7)
+
+		var value uint64
+		var bytes []byte
+		switch wireType {
+		case protoVarint:
+			if value, n = binary.Uvarint(data); n <= 0 {
+				return errProtoTruncated
+			}
+			data = data[n:]
+		case protoFixed64:
+			if len(data) < 8 {
+				return errProtoTruncated
+			}
+			value, data = binary.LittleEndian.Uint64(data), data[8:]
+		case protoFixed32:
+			if len(data) < 4 {
+				return errProtoTruncated
+			}
+			value, data = uint64(binary.LittleEndian.Uint32(data)), data[4:]
+		case protoBytes:
+			length, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < length {
+				return errProtoTruncated
+			}
+			bytes, data = data[n:n+int(length)], data[n+int(length):]
+		default:
+			return fmt.Errorf("unsupported protobuf wire type %d", wireType)
+		}
+
+		for _, field := range fields {
+			if field.number != number {
+				continue
+			}
+			if err := setProtoField(v.Field(field.index), wireType, value, bytes); err != nil {
+				return fmt.Errorf("field %d of %s: %s", number, v.Type().Name(), err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func setProtoField(f reflect.Value, wireType int, value uint64, bytes []byte) error {
+	expected := protoVarint
+	switch f.Kind() {
+	case reflect.String:
+		expected = protoBytes
+		f.SetString(string(bytes))
+	case reflect.Bool:
+		f.SetBool(value != 0)
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		f.SetInt(int64(value))
+	case reflect.Float64:
+		expected = protoFixed64
+		f.SetFloat(math.Float64frombits(value))
+	case reflect.Struct:
+		expected = protoBytes
+		if wireType == protoBytes {
+			return decodeProtoMessage(bytes, f)
+		}
+	case reflect.Slice:
+		element := reflect.New(f.Type().Elem()).Elem()
+		if err := setProtoField(element, wireType, value, bytes); err != nil {
+			return err
+		}
+		f.Set(reflect.Append(f, element))
+		return nil
+	}
+	if wireType != expected {
+		return fmt.Errorf("unexpected wire type %d", wireType)
+	}
+
+	return nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+)
+
+// error codes of the response envelope, clients can rely on them unlike on the messages
+const (
+	errorCodeInvalidRequest   = "invalid_request"
+	errorCodeValidation       = "validation_failed"
+	errorCodePayloadTooLarge  = "payload_too_large"
+	errorCodeRateLimited      = "rate_limited"
+	errorCodeUnauthorized     = "unauthorized"
+	errorCodeForbidden        = "forbidden"
+	errorCodeNotFound         = "not_found"
+	errorCodeMethodNotAllowed = "method_not_allowed"
+	errorCodeStorage          = "storage_error"
+	errorCodeUnavailable      = "service_unavailable"
+	errorCodeInternal         = "internal_error"
+)
+
+func writeEnvelope(w http.ResponseWriter, status int, envelope ResponseRecentData) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(envelope)
+}
+
+// writeResponse answers a successful request that returns no data with the response envelope
+func writeResponse(w http.ResponseWriter, message string) {
+	writeEnvelope(w, http.StatusOK, ResponseRecentData{Status: true, Message: message})
+}
+
+// writeError answers a failed request with the response envelope, details carry e.g. the invalid fields. gRPC calls
+// end with the gRPC status of the code instead.
+func writeError(w http.ResponseWriter, r *http.Request, status int, code, message string, details interface{}) {
+	if isGRPCRequest(r) {
+		writeGRPCStatus(w, grpcStatusOf(code), message)
+		return
+	}
+	requestID, _ := r.Context().Value(requestIDKey).(string)
+	writeEnvelope(w, status, ResponseRecentData{Message: message, Code: code, Details: details, RequestID: requestID})
+}
+
+// writeStorageError answers 500 Internal Server Error because the database failed
+func writeStorageError(w http.ResponseWriter, r *http.Request, message string) {
+	writeError(w, r, http.StatusInternalServerError, errorCodeStorage, message, nil)
+}
+
+// recoverPanics answers 500 Internal Server Error with the response envelope if a handler panics, so that a bug
+// in a handler does not drop the connection without an answer
+func recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+			if recovered == http.ErrAbortHandler {
+				panic(recovered)
+			}
+			loggerFrom(r.Context()).Error("handler panicked", "panic", fmt.Sprint(recovered), "stack", string(debug.Stack()))
+			writeError(w, r, http.StatusInternalServerError, errorCodeInternal, "internal error", nil)
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// notFound answers requests of unknown routes
+func notFound(w http.ResponseWriter, r *http.Request) {
+	if isGRPCRequest(r) {
+		writeGRPCStatus(w, grpcUnimplemented, "unknown method "+r.URL.Path)
+		return
+	}
+	writeError(w, r, http.StatusNotFound, errorCodeNotFound, "no route matches "+r.URL.Path, nil)
+}
+
+// methodNotAllowed answers requests of known routes with another method
+func methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, http.StatusMethodNotAllowed, errorCodeMethodNotAllowed, r.Method+" is not allowed on "+r.URL.Path, nil)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	mgo "gopkg.in/mgo.v2"
+)
+
+const (
+	// retention modes, purge compares when the documents were stored or created, ttl lets the database expire them
+	// after they were stored
+	retentionPurge = "purge"
+	retentionTTL   = "ttl"
+
+	// how the authors of reviews are stored
+	authorsKeep         = "keep"
+	authorsPseudonymize = "pseudonymize"
+	authorsAnonymize    = "anonymize"
+
+	// erasure modes, redact keeps the reviews without their author
+	erasureDelete = "delete"
+	erasureRedact = "redact"
+
+	defaultPurgeInterval = time.Hour
+	pseudonymPrefix      = "pseudonym-"
+)
+
+// retentionTarget is a collection with its retention policy and the unix time field the purge compares. Reviews and
+// pages carry dates like 20191101 instead, they are purged by their stored_at date.
+type retentionTarget struct {
+	collection string
+	timeField  string
+	policy     RetentionPolicy
+}
+
+func retentionTargets() []retentionTarget {
+	return []retentionTarget{
+		{collectionAppReviewsGooglePlay, "", config.Retention.AppReviews},
+		{collectionAppPageGooglePlay, "", config.Retention.AppPages},
+		{collectionAlertGooglePlay, "triggered_at", config.Retention.Alerts},
+		{collectionWebhookDeliveryGooglePlay, "created_at", config.Retention.WebhookDeliveries},
+		{collectionOutbox, "created_at", config.Retention.Outbox},
+		{collectionAudit, "timestamp", config.Retention.Audit},
+	}
+}
+
+// runRetentionPurger purges the expired documents of all tenants periodically until stop is closed
+func runRetentionPurger(mongoClient *mgo.Session, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		forEachTenant(mongoClient, func(m *storageSession) {
+			purgeExpiredDocuments(m, time.Now())
+		})
+	}
+}
+
+// purgeExpiredDocuments removes the documents older than the max age of their collection unless a TTL index expires
+// them and returns the number of removed documents
+func purgeExpiredDocuments(mongoClient *storageSession, now time.Time) int {
+	purged := 0
+	for _, target := range retentionTargets() {
+		if target.policy.MaxAge.Duration <= 0 || target.policy.Mode == retentionTTL {
+			continue
+		}
+		cutoff := now.Add(-target.policy.MaxAge.Duration)
+		field, before := target.timeField, interface{}(cutoff.Unix())
+		if field == "" {
+			field, before = "stored_at", cutoff
+		}
+		removed, ok := MongoRemoveDocumentsBefore(mongoClient, target.collection, field, before)
+		if !ok || removed == 0 {
+			continue
+		}
+		documentsPurged.add(float64(removed), target.collection)
+		loggerFrom(mongoClient.ctx).Info("purged expired documents", "collection", target.collection, "documents", removed)
+		purged += removed
+	}
+
+	return purged
+}
+
+// protectAuthor pseudonymizes or removes the author of a review before it is stored, as configured
+func protectAuthor(review AppReviewGooglePlay) AppReviewGooglePlay {
+	switch config.Retention.Authors {
+	case authorsPseudonymize:
+		if review.Author != "" {
+			review.Author = authorPseudonym(review.Author)
+		}
+	case authorsAnonymize:
+		review.Author = ""
+	}
+
+	return review
+}
+
+// authorPseudonym returns the stable pseudonym of an author, an HMAC of the name keyed by the author secret, so that
+// the reviews of an author can still be related and erased but the name cannot be recovered without the secret
+func authorPseudonym(author string) string {
+	mac := hmac.New(sha256.New, []byte(config.Retention.AuthorSecret))
+	mac.Write([]byte(author))
+
+	return pseudonymPrefix + hex.EncodeToString(mac.Sum(nil))[:32]
+}
+
+// authorNames returns the names the reviews of an author may be stored under, the pseudonym is included as long as
+// the secret is configured, even if authors were kept when some of the reviews were stored
+func authorNames(author string) []string {
+	names := []string{author}
+	if config.Retention.AuthorSecret != "" {
+		names = append(names, authorPseudonym(author))
+	}
+
+	return names
+}
+
+// eraseAuthor deletes or redacts the reviews of the author, redacts the author in the outbox and records the erasure
+// with a hash of the author instead of the name
+func eraseAuthor(mongoClient *storageSession, request ErasureRequest, requestedBy string, now time.Time) (ErasureGooglePlay, bool) {
+	authorHash := sha256.Sum256([]byte(request.Author))
+	erasure := ErasureGooglePlay{
+		ErasureID:    randomToken(16),
+		AuthorSHA256: hex.EncodeToString(authorHash[:]),
+		Mode:         request.Mode,
+		Reason:       request.Reason,
+		RequestedBy:  requestedBy,
+		ErasedAt:     now.Unix(),
+	}
+	if erasure.Mode == "" {
+		erasure.Mode = erasureDelete
+	}
+
+	var ok bool
+	erasure.AppReviews, erasure.OutboxEvents, ok = MongoEraseAuthorGooglePlay(mongoClient, authorNames(request.Author), erasure.Mode)
+	if !ok || !MongoInsertErasureGooglePlay(mongoClient, erasure) {
+		return erasure, false
+	}
+	mongoWriteOutboxEvent(mongoClient, outboxEventAuthorErased, erasure.ErasureID, erasure)
+
+	return erasure, true
+}
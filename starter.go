@@ -1,12 +1,16 @@
 package main
 
 import (
-	"log"
+	"log/slog"
 	"net/http"
 
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/gorilla/mux"
 	mgo "gopkg.in/mgo.v2"
@@ -15,15 +19,98 @@ import (
 var mongoClient *mgo.Session
 
 func main() {
-	log.SetOutput(os.Stdout)
-	mongoClient = MongoGetSession(os.Getenv("MONGO_IP"), os.Getenv("MONGO_USERNAME"), os.Getenv("MONGO_PASSWORD"))
-	MongoCreateCollectionIndexes(mongoClient)
+	loaded, args, err := loadConfig(os.Args[1:], os.Getenv)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	applyConfig(loaded)
+
+	if len(args) == 0 {
+		args = []string{"serve"}
+	}
+	c, ok := commands[args[0]]
+	if !ok {
+		printUsage(os.Stderr)
+		os.Exit(2)
+	}
 
-	router := makeRouter()
+	// the server logs to stdout, the other commands may write their results to stdout
+	logOutput := os.Stderr
+	if c.connects {
+		logOutput = os.Stdout
+	}
+	setupLogging(logOutput, config.LogLevel, config.LogFormat)
+	setupTracing(config.Tracing.OTLPEndpoint, config.Tracing.ServiceName)
+
+	if !c.connects {
+		mongoClient, err = MongoGetSession(config.Mongo)
+		if err != nil {
+			slog.Error("could not connect to the database", "error", err)
+			os.Exit(1)
+		}
+	}
+	err = runCommand(mongoClient, os.Stdout, args)
+	if mongoClient != nil {
+		mongoClient.Close()
+	}
+	shutdownTracing()
+	if err != nil {
+		slog.Error("command failed", "command", args[0], "error", err)
+		os.Exit(1)
+	}
+}
+
+// runServeCommand serves the http api and starts the background jobs as soon as the database is reachable
+func runServeCommand(_ *mgo.Session, out io.Writer, args []string) error {
+	var publisher EventPublisher
+	if config.OutboxPublisher != "" {
+		var err error
+		publisher, err = newEventPublisher(config.OutboxPublisher)
+		if err != nil {
+			return err
+		}
+		defer publisher.Close()
+	}
+
+	server := &http.Server{Addr: config.ListenAddress, Handler: requireReady(makeRouter())}
+	// the gRPC clients connect with HTTP/2 without TLS
+	server.Protocols = new(http.Protocols)
+	server.Protocols.SetHTTP1(true)
+	server.Protocols.SetUnencryptedHTTP2(true)
+
+	slog.Info("server now starts", "address", config.ListenAddress, "version", version)
+	if authentication == nil {
+		slog.Warn("authentication is disabled, every client may call every route")
+	} else if authentication.acceptsNoClient() {
+		slog.Warn("neither API keys nor JWT verification are configured, every request except the probes is rejected")
+	}
 
-	fmt.Println("server now starts")
+	return serveUntilSignal(server, config.ShutdownTimeout.Duration, func(session *mgo.Session, jobs *sync.WaitGroup) {
+		forEachTenant(session, MongoCreateCollectionIndexes)
 
-	log.Fatal(http.ListenAndServe(":9681", router))
+		jobs.Add(3)
+		go func() {
+			defer jobs.Done()
+			runMongoWatchdog(session, mongoWatchdogInterval, shuttingDown)
+		}()
+		go func() {
+			defer jobs.Done()
+			runAlertEvaluator(session, config.AlertEvaluationInterval.Duration, shuttingDown)
+		}()
+		go func() {
+			defer jobs.Done()
+			runRetentionPurger(session, config.Retention.PurgeInterval.Duration, shuttingDown)
+		}()
+		if publisher != nil {
+			jobs.Add(1)
+			go func() {
+				defer jobs.Done()
+				runOutboxRelay(session, publisher, config.OutboxPollInterval.Duration, shuttingDown)
+			}()
+		}
+		startWebhookWorkers(session, jobs, requestsDrained)
+	})
 }
 
 func makeRouter() *mux.Router {
@@ -33,10 +120,52 @@ func makeRouter() *mux.Router {
 	router.HandleFunc("/hitec/repository/app/store/app-review/google-play/", postAppReviewGooglePlay).Methods("POST")
 	router.HandleFunc("/hitec/repository/app/observe/app/google-play/package-name/{package_name}/interval/{interval}", postObserveAppGooglePlay).Methods("POST")
 	router.HandleFunc("/hitec/repository/app/non-existing/app-review/google-play/", postNonExistingAppReviewsGooglePlay).Methods("POST")
+	router.HandleFunc("/hitec/repository/app/alert-rule/google-play/", postAlertRuleGooglePlay).Methods("POST")
+	router.HandleFunc("/hitec/repository/app/alert/google-play/evaluate", postEvaluateAlertRulesGooglePlay).Methods("POST")
+	router.HandleFunc("/hitec/repository/app/subscription/google-play/", postSubscriptionGooglePlay).Methods("POST")
+	router.HandleFunc("/hitec/repository/app/import/app-review/google-play", postImportAppReviewsGooglePlay).Methods("POST")
+	router.HandleFunc("/hitec/repository/app/import/app-page/google-play", postImportAppPagesGooglePlay).Methods("POST")
 
 	// Get
 	router.HandleFunc("/hitec/repository/app/observable/google-play", getObsevableGooglePlay).Methods("GET")
 	router.HandleFunc("/hitec/repository/app/google-play/package-name/{package_name}/class/{class}", getAppReviewsOfClass).Methods("GET")
+	router.HandleFunc("/hitec/repository/app/google-play/package-name/{package_name}/class/{class}/clusters", getAppReviewClustersOfClass).Methods("GET")
+	router.HandleFunc("/hitec/repository/app/alert-rule/google-play/package-name/{package_name}", getAlertRulesGooglePlay).Methods("GET")
+	router.HandleFunc("/hitec/repository/app/alert/google-play/package-name/{package_name}", getAlertsGooglePlay).Methods("GET")
+	router.HandleFunc("/hitec/repository/app/subscription/google-play/", getSubscriptionsGooglePlay).Methods("GET")
+	router.HandleFunc("/hitec/repository/app/subscription/google-play/{subscription_id}/deliveries", getWebhookDeliveriesGooglePlay).Methods("GET")
+	router.HandleFunc("/hitec/repository/app/stream/app-review/google-play", getAppReviewStreamGooglePlay).Methods("GET")
+	router.HandleFunc("/hitec/repository/app/export/app-review/google-play", getExportAppReviewsGooglePlay).Methods("GET")
+	router.HandleFunc("/hitec/repository/app/export/app-page/google-play", getExportAppPagesGooglePlay).Methods("GET")
+
+	// v2
+	for _, route := range v2Routes() {
+		router.HandleFunc(v2Prefix+route.path, route.handler).Methods(route.method)
+	}
+
+	// GraphQL
+	router.HandleFunc("/graphql", postGraphQL).Methods("POST")
+	router.HandleFunc("/graphql", getGraphQL).Methods("GET")
+	router.HandleFunc("/graphql/schema", getGraphQLSchema).Methods("GET")
+
+	// gRPC
+	for _, method := range grpcMethods() {
+		router.HandleFunc(grpcPath(method.name), method.handler).Methods("POST")
+	}
+
+	// Health
+	router.HandleFunc("/healthz", getHealthz).Methods("GET")
+	router.HandleFunc("/readyz", getReadyz).Methods("GET")
+	router.HandleFunc("/metrics", getMetrics).Methods("GET")
+
+	// Delete
+	router.HandleFunc("/hitec/repository/app/alert-rule/google-play/package-name/{package_name}/type/{type}", deleteAlertRuleGooglePlay).Methods("DELETE")
+	router.HandleFunc("/hitec/repository/app/subscription/google-play/{subscription_id}", deleteSubscriptionGooglePlay).Methods("DELETE")
+
+	router.NotFoundHandler = http.HandlerFunc(notFound)
+	router.MethodNotAllowedHandler = http.HandlerFunc(methodNotAllowed)
+	// the metrics are recorded outside of recoverPanics, so that the 500 answers of panicking handlers are counted
+	router.Use(traceRequest, instrumentRoute, recoverPanics, limitRequests, authorize)
 
 	return router
 }
@@ -46,21 +175,29 @@ func postAppPageGooglePlay(w http.ResponseWriter, r *http.Request) {
 	var appPage AppPageGooglePlay
 	err := json.NewDecoder(r.Body).Decode(&appPage)
 	if err != nil {
-		fmt.Printf("ERROR: %s for request body: %v\n", err, r.Body)
-		w.WriteHeader(http.StatusBadRequest)
+		loggerFrom(r.Context()).Warn("invalid request body", "error", err)
+		writeDecodeError(w, r, err)
+		return
+	}
+	if errs := validateAppPage(appPage); len(errs) > 0 {
+		writeValidationErrors(w, r, errs)
 		return
 	}
 
 	// insert data into the db
-	m := mongoClient.Copy()
-	defer m.Close()
-	ok := MongoInsertAppPageGooglePlay(m, appPage)
+	m, release := requestSession(r)
+	defer release()
+	isNew, ok := MongoInsertAppPageGooglePlay(m, appPage)
+	if isNew {
+		notifyAppPageSubscribers(m, appPage)
+	}
+	auditAppPage(r, m, appPage, isNew, ok)
 
 	// send response
 	if ok {
-		w.WriteHeader(http.StatusOK)
+		writeResponse(w, "app page stored")
 	} else {
-		w.WriteHeader(http.StatusInternalServerError)
+		writeStorageError(w, r, "could not store the app page")
 	}
 }
 
@@ -69,21 +206,65 @@ func postAppReviewGooglePlay(w http.ResponseWriter, r *http.Request) {
 	var appReviews []AppReviewGooglePlay
 	err := json.NewDecoder(r.Body).Decode(&appReviews)
 	if err != nil {
-		fmt.Printf("ERROR: %s for request body: %v\n", err, r.Body)
-		w.WriteHeader(http.StatusBadRequest)
+		loggerFrom(r.Context()).Warn("invalid request body", "error", err)
+		writeDecodeError(w, r, err)
+		return
+	}
+	if exceedsMaxArrayLength(len(appReviews)) {
+		loggerFrom(r.Context()).Warn("too many app reviews", "app_reviews", len(appReviews), "max", config.Limits.MaxArrayLength)
+		writeArrayTooLong(w, r)
+		return
+	}
+	if errs := validateAppReviews(appReviews); len(errs) > 0 {
+		writeValidationErrors(w, r, errs)
 		return
 	}
 
 	// insert data into the db
-	m := mongoClient.Copy()
-	defer m.Close()
+	m, release := requestSession(r)
+	defer release()
+	inserted, updated, ok := storeAppReviews(m, tenantFrom(r.Context()), appReviews)
+	auditAppReviews(r, m, appReviews, inserted, updated, ok)
+
+	// send response
+	if ok {
+		writeResponse(w, fmt.Sprintf("%d app reviews stored", len(appReviews)))
+	} else {
+		writeStorageError(w, r, "could not store the app reviews")
+	}
+}
+
+// storeAppReviews inserts the reviews with their authors protected as configured, publishes them to the stream of the
+// tenant and notifies the subscribers of the new ones. It returns the number of inserted and updated reviews and ok if
+// all reviews were stored.
+func storeAppReviews(m *storageSession, tenant string, appReviews []AppReviewGooglePlay) (inserted, updated int, ok bool) {
+	var stored, newReviews []AppReviewGooglePlay
 	for _, review := range appReviews {
-		MongoInsertAppReviewGooglePlay(m, review)
+		review = protectAuthor(review)
+		isNew, ok := MongoInsertAppReviewGooglePlay(m, review)
+		switch {
+		case isNew:
+			inserted++
+			newReviews = append(newReviews, review)
+		case ok:
+			updated++
+		}
+		if ok {
+			stored = append(stored, review)
+		}
 	}
+	announceAppReviews(m, tenant, stored, newReviews)
 
-	// send response
-	w.WriteHeader(http.StatusOK)
-	w.Header().Set("Content-Type", "application/json")
+	return inserted, updated, inserted+updated == len(appReviews)
+}
+
+// announceAppReviews publishes the stored reviews to the review stream of the tenant and delivers the new ones to the
+// subscriptions
+func announceAppReviews(m *storageSession, tenant string, stored, newReviews []AppReviewGooglePlay) {
+	for _, review := range stored {
+		reviewStream.publish(tenant, review)
+	}
+	notifyReviewSubscribers(m, newReviews)
 }
 
 func postObserveAppGooglePlay(w http.ResponseWriter, r *http.Request) {
@@ -93,17 +274,22 @@ func postObserveAppGooglePlay(w http.ResponseWriter, r *http.Request) {
 	interval := params["interval"] // possible intervals: minutely, hourly, daily, monthly
 
 	var observalbe = ObservableGooglePlay{PackageName: packageName, Interval: interval}
+	if errs := validateObservable(observalbe); len(errs) > 0 {
+		writeValidationErrors(w, r, errs)
+		return
+	}
 
 	// insert data into the db
-	m := mongoClient.Copy()
-	defer m.Close()
+	m, release := requestSession(r)
+	defer release()
 	ok := MongoInsertObservableGooglePlay(m, observalbe)
+	audit(r, m, auditKey(packageName), AuditEntryGooglePlay{Action: auditStoreObservable, Failed: !ok})
 
 	// send response
 	if ok {
-		w.WriteHeader(http.StatusOK)
+		writeResponse(w, "observable stored")
 	} else {
-		w.WriteHeader(http.StatusInternalServerError)
+		writeStorageError(w, r, "could not store the observable")
 	}
 }
 
@@ -112,14 +298,23 @@ func postNonExistingAppReviewsGooglePlay(w http.ResponseWriter, r *http.Request)
 	var appReviews []AppReviewGooglePlay
 	err := json.NewDecoder(r.Body).Decode(&appReviews)
 	if err != nil {
-		fmt.Printf("ERROR: %s for request body: %v\n", err, r.Body)
-		w.WriteHeader(http.StatusBadRequest)
+		loggerFrom(r.Context()).Warn("invalid request body", "error", err)
+		writeDecodeError(w, r, err)
+		return
+	}
+	if exceedsMaxArrayLength(len(appReviews)) {
+		loggerFrom(r.Context()).Warn("too many app reviews", "app_reviews", len(appReviews), "max", config.Limits.MaxArrayLength)
+		writeArrayTooLong(w, r)
+		return
+	}
+	if errs := validateAppReviews(appReviews); len(errs) > 0 {
+		writeValidationErrors(w, r, errs)
 		return
 	}
 
 	// insert data into the db
-	m := mongoClient.Copy()
-	defer m.Close()
+	m, release := requestSession(r)
+	defer release()
 	nonExistingAppReviews := MongoGetNonExistingAppReviewGooglePlay(m, appReviews)
 
 	// send response
@@ -130,8 +325,8 @@ func postNonExistingAppReviewsGooglePlay(w http.ResponseWriter, r *http.Request)
 
 func getObsevableGooglePlay(w http.ResponseWriter, r *http.Request) {
 	// get data from the db
-	m := mongoClient.Copy()
-	defer m.Close()
+	m, release := requestSession(r)
+	defer release()
 	observables := MongoGetAllObservableGooglePlay(m)
 
 	// send response
@@ -145,13 +340,455 @@ func getAppReviewsOfClass(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
 	packageName := params["package_name"]
 	reviewClass := params["class"]
+	minConfidence, err := queryFloat(r, "min_confidence", labelConfidenceThreshold)
+	if err != nil {
+		loggerFrom(r.Context()).Warn("invalid min_confidence", "error", err)
+		writeError(w, r, http.StatusBadRequest, errorCodeInvalidRequest, "min_confidence must be a number", nil)
+		return
+	}
+	excludeDuplicates, err := queryBool(r, "exclude_duplicates", false)
+	if err != nil {
+		loggerFrom(r.Context()).Warn("invalid exclude_duplicates", "error", err)
+		writeError(w, r, http.StatusBadRequest, errorCodeInvalidRequest, "exclude_duplicates must be true or false", nil)
+		return
+	}
 
 	// query db
-	m := mongoClient.Copy()
-	bugReports := MongoGetGooglePlayReviewOfClass(m, packageName, reviewClass)
+	m, release := requestSession(r)
+	defer release()
+	bugReports := MongoGetGooglePlayReviewOfClass(m, packageName, reviewClass, minConfidence, excludeDuplicates)
 
 	// if no recent data exist, return false
 	w.WriteHeader(http.StatusOK)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(bugReports)
 }
+
+func getAppReviewClustersOfClass(w http.ResponseWriter, r *http.Request) {
+	// get request param
+	params := mux.Vars(r)
+	packageName := params["package_name"]
+	reviewClass := params["class"]
+	minConfidence, err := queryFloat(r, "min_confidence", labelConfidenceThreshold)
+	if err != nil {
+		loggerFrom(r.Context()).Warn("invalid min_confidence", "error", err)
+		writeError(w, r, http.StatusBadRequest, errorCodeInvalidRequest, "min_confidence must be a number", nil)
+		return
+	}
+	k, err := queryInt(r, "k", 0)
+	if err != nil || k < 0 || k > maxClusters {
+		loggerFrom(r.Context()).Warn("invalid k", "k", r.URL.Query().Get("k"))
+		writeError(w, r, http.StatusBadRequest, errorCodeInvalidRequest, fmt.Sprintf("k must be between 0 and %d", maxClusters), nil)
+		return
+	}
+
+	// query db
+	m, release := requestSession(r)
+	defer release()
+	reviews := MongoGetGooglePlayReviewOfClass(m, packageName, reviewClass, minConfidence, true)
+
+	// cluster the reviews
+	if k == 0 {
+		k = defaultClusterCount(len(reviews))
+	}
+	clusters := clusterReviews(reviews, k)
+
+	// send response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(clusters)
+}
+
+func postAlertRuleGooglePlay(w http.ResponseWriter, r *http.Request) {
+	// get data from the request
+	var rule AlertRuleGooglePlay
+	err := json.NewDecoder(r.Body).Decode(&rule)
+	if err != nil {
+		loggerFrom(r.Context()).Warn("invalid alert rule body", "error", err)
+		writeDecodeError(w, r, err)
+		return
+	}
+	if errs := validateAlertRule(rule); len(errs) > 0 {
+		writeValidationErrors(w, r, errs)
+		return
+	}
+	rule.LastTriggered = 0
+
+	// insert data into the db
+	m, release := requestSession(r)
+	defer release()
+	ok := MongoInsertAlertRuleGooglePlay(m, rule)
+	audit(r, m, alertRuleKeys(rule.PackageName, rule.Type), AuditEntryGooglePlay{Action: auditStoreAlertRule, Failed: !ok})
+
+	// send response
+	if ok {
+		writeResponse(w, "alert rule stored")
+	} else {
+		writeStorageError(w, r, "could not store the alert rule")
+	}
+}
+
+func postEvaluateAlertRulesGooglePlay(w http.ResponseWriter, r *http.Request) {
+	// evaluate the rules against the db
+	m, release := requestSession(r)
+	defer release()
+	alerts := evaluateAlertRules(m, time.Now())
+	keys := &auditKeys{}
+	for _, alert := range alerts {
+		keys.add(alert.PackageName)
+	}
+	audit(r, m, keys, AuditEntryGooglePlay{Action: auditEvaluateAlerts, Counts: map[string]int{"triggered": len(alerts)}})
+
+	// send response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(alerts)
+}
+
+func getAlertRulesGooglePlay(w http.ResponseWriter, r *http.Request) {
+	// get request param
+	params := mux.Vars(r)
+	packageName := params["package_name"]
+
+	// query db
+	m, release := requestSession(r)
+	defer release()
+	rules := MongoGetAlertRulesGooglePlay(m, packageName)
+
+	// send response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(rules)
+}
+
+func getAlertsGooglePlay(w http.ResponseWriter, r *http.Request) {
+	// get request param
+	params := mux.Vars(r)
+	packageName := params["package_name"]
+
+	// query db
+	m, release := requestSession(r)
+	defer release()
+	alerts := MongoGetAlertsGooglePlay(m, packageName)
+
+	// send response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(alerts)
+}
+
+func deleteAlertRuleGooglePlay(w http.ResponseWriter, r *http.Request) {
+	// get request param
+	params := mux.Vars(r)
+	packageName := params["package_name"]
+	ruleType := params["type"]
+
+	// delete data from the db
+	m, release := requestSession(r)
+	defer release()
+	ok := MongoDeleteAlertRuleGooglePlay(m, packageName, ruleType)
+	audit(r, m, alertRuleKeys(packageName, ruleType), AuditEntryGooglePlay{Action: auditDeleteAlertRule, Failed: !ok})
+
+	// send response
+	if ok {
+		writeResponse(w, "alert rule deleted")
+	} else {
+		writeStorageError(w, r, "could not delete the alert rule")
+	}
+}
+
+func postSubscriptionGooglePlay(w http.ResponseWriter, r *http.Request) {
+	// get data from the request
+	var subscription SubscriptionGooglePlay
+	err := json.NewDecoder(r.Body).Decode(&subscription)
+	if err != nil {
+		loggerFrom(r.Context()).Warn("invalid subscription body", "error", err)
+		writeDecodeError(w, r, err)
+		return
+	}
+	if errs := validateSubscription(subscription); len(errs) > 0 {
+		writeValidationErrors(w, r, errs)
+		return
+	}
+	subscription.SubscriptionID = randomToken(16)
+	if subscription.Secret == "" {
+		subscription.Secret = randomToken(32)
+	}
+	subscription.CreatedAt = time.Now().Unix()
+
+	// insert data into the db
+	m, release := requestSession(r)
+	defer release()
+	ok := MongoInsertSubscriptionGooglePlay(m, subscription)
+	audit(r, m, auditKey(subscription.SubscriptionID), AuditEntryGooglePlay{Action: auditStoreSubscription, Failed: !ok})
+
+	// send response
+	if !ok {
+		writeStorageError(w, r, "could not store the subscription")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(subscription)
+}
+
+func getSubscriptionsGooglePlay(w http.ResponseWriter, r *http.Request) {
+	// query db
+	m, release := requestSession(r)
+	defer release()
+	subscriptions := MongoGetAllSubscriptionGooglePlay(m)
+	for i := range subscriptions {
+		subscriptions[i].Secret = ""
+	}
+
+	// send response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(subscriptions)
+}
+
+func getWebhookDeliveriesGooglePlay(w http.ResponseWriter, r *http.Request) {
+	// get request param
+	params := mux.Vars(r)
+	subscriptionID := params["subscription_id"]
+
+	// query db
+	m, release := requestSession(r)
+	defer release()
+	deliveries := MongoGetWebhookDeliveriesGooglePlay(m, subscriptionID)
+
+	// send response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(deliveries)
+}
+
+func deleteSubscriptionGooglePlay(w http.ResponseWriter, r *http.Request) {
+	// get request param
+	params := mux.Vars(r)
+	subscriptionID := params["subscription_id"]
+
+	// delete data from the db
+	m, release := requestSession(r)
+	defer release()
+	found, ok := MongoDeleteSubscriptionGooglePlay(m, subscriptionID)
+	audit(r, m, auditKey(subscriptionID), AuditEntryGooglePlay{Action: auditDeleteSubscription, Counts: deletedCount(found), Failed: !ok})
+
+	// send response
+	if !ok {
+		writeStorageError(w, r, "could not delete the subscription")
+	} else if !found {
+		writeError(w, r, http.StatusNotFound, errorCodeNotFound, "no subscription "+subscriptionID, nil)
+	} else {
+		writeResponse(w, "subscription deleted")
+	}
+}
+
+func getAppReviewStreamGooglePlay(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, r, http.StatusInternalServerError, errorCodeInternal, "the connection does not support streaming", nil)
+		return
+	}
+
+	// get request param
+	filter := reviewFilter{
+		tenant:      tenantFrom(r.Context()),
+		packageName: r.URL.Query().Get("package_name"),
+		class:       r.URL.Query().Get("class"),
+	}
+	ch := reviewStream.subscribe(filter)
+	defer reviewStream.unsubscribe(ch)
+
+	// send server-sent events until the client disconnects
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ": connected\n\n")
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(reviewStreamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-shuttingDown:
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+		case review := <-ch:
+			data, err := json.Marshal(review)
+			if err != nil {
+				loggerFrom(r.Context()).Error("could not encode review", "review_id", review.ReviewID, "error", err)
+				continue
+			}
+			fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", review.ReviewID, eventAppReview, data)
+		}
+		flusher.Flush()
+	}
+}
+
+func getExportAppReviewsGooglePlay(w http.ResponseWriter, r *http.Request) {
+	exportGooglePlay(w, r, "app_reviews_google_play", exportAppReviews)
+}
+
+func getExportAppPagesGooglePlay(w http.ResponseWriter, r *http.Request) {
+	exportGooglePlay(w, r, "app_pages_google_play", exportAppPages)
+}
+
+// exportGooglePlay streams the documents selected by the query parameters with the given export function
+func exportGooglePlay(w http.ResponseWriter, r *http.Request, fileName string, export func(*storageSession, io.Writer, string, exportFilter) (int, error)) {
+	// get request param
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = exportFormatJSONLines
+	}
+	from, errFrom := queryInt64(r, "from", 0)
+	to, errTo := queryInt64(r, "to", 0)
+	_, fromOK := parseDate(from)
+	_, toOK := parseDate(to)
+	if !isValidExportFormat(format) || errFrom != nil || errTo != nil || (from != 0 && !fromOK) || (to != 0 && !toOK) {
+		loggerFrom(r.Context()).Warn("invalid export parameters", "query", r.URL.RawQuery)
+		writeError(w, r, http.StatusBadRequest, errorCodeInvalidRequest, "format must be jsonl or csv, from and to dates like 20191101", nil)
+		return
+	}
+	filter := exportFilter{packageName: r.URL.Query().Get("package_name"), from: from, to: to}
+
+	// stream data from the db
+	m, release := requestSession(r)
+	defer release()
+	w.Header().Set("Content-Type", exportContentTypes[format])
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s.%s", fileName, format))
+	w.WriteHeader(http.StatusOK)
+	count, err := export(m, w, format, filter)
+	if err != nil {
+		loggerFrom(r.Context()).Error("export stopped", "export", fileName, "documents", count, "error", err)
+	}
+}
+
+func postImportAppReviewsGooglePlay(w http.ResponseWriter, r *http.Request) {
+	importGooglePlay(w, r, auditImportAppReviews, importAppReviews)
+}
+
+func postImportAppPagesGooglePlay(w http.ResponseWriter, r *http.Request) {
+	importGooglePlay(w, r, auditImportAppPages, importAppPages)
+}
+
+// importGooglePlay stores the records of the streamed request body with the given import function and audits the
+// import as the given action
+func importGooglePlay(w http.ResponseWriter, r *http.Request, action string, importFile func(*storageSession, io.Reader, string, *auditKeys) (ImportSummary, error)) {
+	// get request param
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = exportFormatJSONLines
+	}
+	if !isValidExportFormat(format) {
+		loggerFrom(r.Context()).Warn("invalid import format", "format", format)
+		writeError(w, r, http.StatusBadRequest, errorCodeInvalidRequest, "format must be jsonl or csv", nil)
+		return
+	}
+
+	// insert data into the db
+	m, release := requestSession(r)
+	defer release()
+	keys := &auditKeys{}
+	summary, err := importFile(m, r.Body, format, keys)
+	auditImport(r, m, action, keys, summary, err)
+
+	// send response, failures carry the summary of the records imported so far as details
+	if err == errImportStore {
+		writeError(w, r, http.StatusInternalServerError, errorCodeStorage, "import stopped: "+err.Error(), summary)
+		return
+	}
+	if err != nil {
+		loggerFrom(r.Context()).Error("import stopped", "records", summary.Read, "error", err)
+		writeError(w, r, http.StatusBadRequest, errorCodeInvalidRequest, "import stopped: "+err.Error(), summary)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(summary)
+}
+
+// queryFloat returns the float query parameter with the given name or the fallback if it is not set
+func queryFloat(r *http.Request, name string, fallback float64) (float64, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return fallback, nil
+	}
+
+	return strconv.ParseFloat(value, 64)
+}
+
+// queryInt returns the integer query parameter with the given name or the fallback if it is not set
+func queryInt(r *http.Request, name string, fallback int) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return fallback, nil
+	}
+
+	return strconv.Atoi(value)
+}
+
+// queryInt64 returns the 64-bit integer query parameter with the given name or the fallback if it is not set
+func queryInt64(r *http.Request, name string, fallback int64) (int64, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return fallback, nil
+	}
+
+	return strconv.ParseInt(value, 10, 64)
+}
+
+// queryBool returns the boolean query parameter with the given name or the fallback if it is not set
+func queryBool(r *http.Request, name string, fallback bool) (bool, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return fallback, nil
+	}
+
+	return strconv.ParseBool(value)
+}
+
+// getHealthz is the liveness probe, it answers as long as the process runs and reports the database connectivity
+func getHealthz(w http.ResponseWriter, r *http.Request) {
+	status := healthStatus(checkDatabase(currentMongoClient()))
+
+	// send response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(status)
+}
+
+// getReadyz is the readiness probe, it fails until the database is reachable and all indexes exist
+func getReadyz(w http.ResponseWriter, r *http.Request) {
+	m := currentMongoClient()
+	status := healthStatus(checkReadiness(), checkDatabase(m), checkIndexes(m))
+
+	// send response
+	w.Header().Set("Content-Type", "application/json")
+	if status.Status == healthStatusOK {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(status)
+}
+
+// getMetrics exposes the http and storage metrics in the Prometheus text format
+func getMetrics(w http.ResponseWriter, r *http.Request) {
+	// get data from the db, unless it is not reachable
+	m := currentMongoClient()
+	if check := checkDatabase(m); check.Status == healthStatusOK {
+		m = m.Copy()
+		updateCollectionDocumentGauges(m)
+		m.Close()
+	}
+
+	// send response
+	w.Header().Set("Content-Type", metricsContentType)
+	w.WriteHeader(http.StatusOK)
+	writeMetrics(w)
+}
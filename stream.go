@@ -0,0 +1,74 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// reviewStreamBuffer is the number of reviews buffered per listener, further reviews are dropped for slow listeners
+	reviewStreamBuffer = 64
+
+	reviewStreamHeartbeat = 15 * time.Second
+)
+
+// reviewFilter selects the reviews a stream listener receives, empty fields except the tenant match everything
+type reviewFilter struct {
+	tenant      string
+	packageName string
+	class       string
+}
+
+func (f reviewFilter) matches(tenant string, review AppReviewGooglePlay) bool {
+	if f.tenant != tenant {
+		return false
+	}
+	if f.packageName != "" && f.packageName != review.PackageName {
+		return false
+	}
+	if f.class != "" {
+		confidence, ok := labelConfidence(review, f.class)
+		return ok && confidence >= labelConfidenceThreshold
+	}
+
+	return true
+}
+
+// reviewBroker fans stored reviews out to the connected stream listeners
+type reviewBroker struct {
+	mu        sync.Mutex
+	listeners map[chan AppReviewGooglePlay]reviewFilter
+}
+
+var reviewStream = &reviewBroker{listeners: map[chan AppReviewGooglePlay]reviewFilter{}}
+
+func (b *reviewBroker) subscribe(filter reviewFilter) chan AppReviewGooglePlay {
+	ch := make(chan AppReviewGooglePlay, reviewStreamBuffer)
+	b.mu.Lock()
+	b.listeners[ch] = filter
+	b.mu.Unlock()
+
+	return ch
+}
+
+func (b *reviewBroker) unsubscribe(ch chan AppReviewGooglePlay) {
+	b.mu.Lock()
+	delete(b.listeners, ch)
+	b.mu.Unlock()
+}
+
+// publish passes the review of the tenant to every listener whose filter matches without blocking the caller
+func (b *reviewBroker) publish(tenant string, review AppReviewGooglePlay) {
+	review = syncReviewLabels(review)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch, filter := range b.listeners {
+		if !filter.matches(tenant, review) {
+			continue
+		}
+		select {
+		case ch <- review:
+		default:
+		}
+	}
+}
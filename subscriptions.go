@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+
+	mgo "gopkg.in/mgo.v2"
+)
+
+const (
+	eventAppReview = "app_review"
+	eventAppPage   = "app_page"
+
+	// webhookWorkers deliver the queued notifications concurrently, each with a session of its own
+	webhookWorkers = 8
+
+	// webhookQueueSize limits the notifications waiting for a worker, further notifications are dropped
+	webhookQueueSize = 1000
+)
+
+// webhookRetryDelays are the waits before the retries of a failed webhook delivery
+var webhookRetryDelays = []time.Duration{time.Second, 10 * time.Second, time.Minute}
+
+// webhookQueue holds the notifications waiting for a delivery worker
+var webhookQueue = make(chan webhookNotificationJob, webhookQueueSize)
+
+// webhookNotificationJob is a queued notification, ctx carries the tenant and the request that stored the data
+type webhookNotificationJob struct {
+	ctx          context.Context
+	subscription SubscriptionGooglePlay
+	event        string
+	data         interface{}
+	itemCount    int
+}
+
+// isValidEvent returns true if subscriptions can be notified about the given event
+func isValidEvent(event string) bool {
+	return event == eventAppReview || event == eventAppPage
+}
+
+// randomToken returns a random hex string of n bytes
+func randomToken(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+
+	return hex.EncodeToString(b)
+}
+
+// subscribesTo returns true if the subscription wants to be notified about the given event of the given package
+func subscribesTo(subscription SubscriptionGooglePlay, event, packageName string) bool {
+	if subscription.PackageName != "" && subscription.PackageName != packageName {
+		return false
+	}
+	if len(subscription.Events) == 0 {
+		return true
+	}
+	for _, e := range subscription.Events {
+		if e == event {
+			return true
+		}
+	}
+
+	return false
+}
+
+// reviewMatchesSubscription returns true if the review passes the filters of the subscription
+func reviewMatchesSubscription(subscription SubscriptionGooglePlay, review AppReviewGooglePlay) bool {
+	if !subscribesTo(subscription, eventAppReview, review.PackageName) {
+		return false
+	}
+	if review.Rating < subscription.MinRating {
+		return false
+	}
+	if subscription.Class != "" {
+		confidence, ok := labelConfidence(syncReviewLabels(review), subscription.Class)
+		return ok && confidence >= labelConfidenceThreshold
+	}
+
+	return true
+}
+
+// notifyReviewSubscribers queues the newly stored reviews for every subscription they match
+func notifyReviewSubscribers(mongoClient *storageSession, reviews []AppReviewGooglePlay) {
+	if len(reviews) == 0 {
+		return
+	}
+
+	for _, subscription := range MongoGetAllSubscriptionGooglePlay(mongoClient) {
+		var matching []AppReviewGooglePlay
+		for _, review := range reviews {
+			if reviewMatchesSubscription(subscription, review) {
+				matching = append(matching, review)
+			}
+		}
+		if len(matching) > 0 {
+			queueNotification(mongoClient, subscription, eventAppReview, matching, len(matching))
+		}
+	}
+}
+
+// notifyAppPageSubscribers queues the newly stored app pages for every subscription they match
+func notifyAppPageSubscribers(mongoClient *storageSession, appPages ...AppPageGooglePlay) {
+	if len(appPages) == 0 {
+		return
+	}
+
+	for _, subscription := range MongoGetAllSubscriptionGooglePlay(mongoClient) {
+		var matching []AppPageGooglePlay
+		for _, appPage := range appPages {
+			if subscribesTo(subscription, eventAppPage, appPage.PackageName) {
+				matching = append(matching, appPage)
+			}
+		}
+		if len(matching) > 0 {
+			queueNotification(mongoClient, subscription, eventAppPage, matching, len(matching))
+		}
+	}
+}
+
+// queueNotification hands a notification to the delivery workers. If the queue is full the notification is dropped
+// and logged as a failed delivery.
+func queueNotification(mongoClient *storageSession, subscription SubscriptionGooglePlay, event string, data interface{}, itemCount int) {
+	job := webhookNotificationJob{
+		ctx:          context.WithoutCancel(mongoClient.ctx),
+		subscription: subscription,
+		event:        event,
+		data:         data,
+		itemCount:    itemCount,
+	}
+	select {
+	case webhookQueue <- job:
+		return
+	default:
+	}
+
+	loggerFrom(mongoClient.ctx).Error("could not queue notification, the queue is full", "event", event, "subscription_id", subscription.SubscriptionID)
+	now := time.Now().Unix()
+	MongoInsertWebhookDeliveryGooglePlay(mongoClient, WebhookDeliveryGooglePlay{
+		SubscriptionID: subscription.SubscriptionID,
+		Event:          event,
+		URL:            subscription.URL,
+		ItemCount:      itemCount,
+		Error:          "dropped, the delivery queue is full",
+		CreatedAt:      now,
+		FinishedAt:     now,
+	})
+}
+
+// startWebhookWorkers starts the workers delivering the queued notifications with copies of the session. Once stop is
+// closed they deliver the notifications still queued without retrying and return, jobs is done when all returned.
+func startWebhookWorkers(session *mgo.Session, jobs *sync.WaitGroup, stop <-chan struct{}) {
+	jobs.Add(webhookWorkers)
+	for i := 0; i < webhookWorkers; i++ {
+		go func() {
+			defer jobs.Done()
+			runWebhookWorker(session, stop)
+		}()
+	}
+}
+
+func runWebhookWorker(session *mgo.Session, stop <-chan struct{}) {
+	deliver := func(job webhookNotificationJob) {
+		m, _ := bindSession(session, job.ctx)
+		deliverNotification(m, job.subscription, job.event, job.data, job.itemCount, stop)
+	}
+	for {
+		select {
+		case job := <-webhookQueue:
+			deliver(job)
+		case <-stop:
+			for {
+				select {
+				case job := <-webhookQueue:
+					deliver(job)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// waitOrStop waits for the delay and returns false if stop is closed before
+func waitOrStop(delay time.Duration, stop <-chan struct{}) bool {
+	select {
+	case <-stop:
+		return false
+	case <-time.After(delay):
+		return true
+	}
+}
+
+// deliverNotification posts the data to the subscription url, retrying failed attempts until stop is closed, and logs
+// the delivery. It closes the given session when done.
+func deliverNotification(mongoClient *storageSession, subscription SubscriptionGooglePlay, event string, data interface{}, itemCount int, stop <-chan struct{}) {
+	defer mongoClient.close()
+
+	delivery := WebhookDeliveryGooglePlay{
+		SubscriptionID: subscription.SubscriptionID,
+		Event:          event,
+		URL:            subscription.URL,
+		ItemCount:      itemCount,
+		CreatedAt:      time.Now().Unix(),
+	}
+	body, err := json.Marshal(WebhookNotification{
+		SubscriptionID: subscription.SubscriptionID,
+		Event:          event,
+		SentAt:         delivery.CreatedAt,
+		Data:           data,
+	})
+	if err != nil {
+		delivery.Error = err.Error()
+	} else {
+		for attempt := 0; attempt <= len(webhookRetryDelays) && !delivery.Delivered; attempt++ {
+			if attempt > 0 && !waitOrStop(webhookRetryDelays[attempt-1], stop) {
+				break
+			}
+			delivery.Attempts++
+			delivery.StatusCode, err = sendWebhook(subscription.URL, subscription.Secret, body)
+			delivery.Delivered = err == nil
+			if err != nil {
+				delivery.Error = err.Error()
+			} else {
+				delivery.Error = ""
+			}
+		}
+	}
+	if !delivery.Delivered {
+		loggerFrom(mongoClient.ctx).Error("could not deliver notification", "event", event, "subscription_id", subscription.SubscriptionID, "error", delivery.Error)
+	}
+
+	delivery.FinishedAt = time.Now().Unix()
+	MongoInsertWebhookDeliveryGooglePlay(mongoClient, delivery)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"regexp"
+
+	mgo "gopkg.in/mgo.v2"
+)
+
+// maxTenantLength keeps the database names of the tenants within the limit of MongoDB
+const maxTenantLength = 32
+
+var tenantPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+func isValidTenant(tenant string) bool {
+	return len(tenant) <= maxTenantLength && tenantPattern.MatchString(tenant)
+}
+
+// isKnownTenant returns true for the default tenant and the configured tenants
+func isKnownTenant(tenant string) bool {
+	if tenant == "" {
+		return true
+	}
+	for _, t := range config.Tenants {
+		if t == tenant {
+			return true
+		}
+	}
+
+	return false
+}
+
+// allTenants returns the default tenant, named by the empty string, followed by the configured tenants
+func allTenants() []string {
+	return append([]string{""}, config.Tenants...)
+}
+
+func withTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey, tenant)
+}
+
+// tenantFrom returns the tenant of the context, the default tenant if it has none
+func tenantFrom(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantKey).(string)
+	return tenant
+}
+
+// tenantDatabaseName returns the name of the database holding the data of the tenant. The default tenant uses the
+// configured database, the other tenants a database named after it and the tenant.
+func tenantDatabaseName(tenant string) string {
+	if tenant == "" {
+		return database
+	}
+
+	return database + "_" + tenant
+}
+
+// mongoDatabase returns the database of the tenant bound to the session
+func mongoDatabase(mongoClient *storageSession) *mgo.Database {
+	return mongoClient.session.DB(tenantDatabaseName(tenantFrom(mongoClient.ctx)))
+}
+
+// tenantSession returns a copy of the session whose storage operations use the database of the tenant. release
+// closes the copy.
+func tenantSession(mongoClient *mgo.Session, tenant string) (m *storageSession, release func()) {
+	return bindSession(mongoClient, withTenant(context.Background(), tenant))
+}
+
+// forEachTenant calls f with a session bound to each tenant, e.g. to run a background job for all tenants
+func forEachTenant(mongoClient *mgo.Session, f func(m *storageSession)) {
+	for _, tenant := range allTenants() {
+		m, release := tenantSession(mongoClient, tenant)
+		f(m)
+		release()
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	traceParentHeader = "traceparent"
+
+	// span kinds and status codes of the OpenTelemetry protocol
+	spanKindInternal = 1
+	spanKindServer   = 2
+	spanKindClient   = 3
+	spanStatusOK     = 1
+	spanStatusError  = 2
+
+	spanQueueSize       = 2048
+	spanBatchSize       = 512
+	spanExportInterval  = 5 * time.Second
+	spanExportTimeout   = 10 * time.Second
+	defaultServiceName  = "ri-storage-app"
+	otlpTracesPath      = "/v1/traces"
+	otlpJSONContentType = "application/json"
+)
+
+// span is a timed operation of a trace in the OpenTelemetry data model
+type span struct {
+	traceID    [16]byte
+	spanID     [8]byte
+	parentID   [8]byte
+	name       string
+	kind       int
+	start      time.Time
+	finish     time.Time
+	attributes map[string]string
+	err        string
+}
+
+// spanParent identifies the span a new span continues, e.g. the span of a client passed in the traceparent header
+type spanParent struct {
+	traceID [16]byte
+	spanID  [8]byte
+}
+
+// tracer exports the finished spans, it is nil if tracing is disabled
+var tracer *otlpExporter
+
+func spanFrom(ctx context.Context) *span {
+	s, _ := ctx.Value(spanKey).(*span)
+	return s
+}
+
+// startSpan starts a span as child of the span in the context or of the given remote parent and returns a context
+// carrying it. It returns a nil span, whose methods do nothing, if tracing is disabled.
+func startSpan(ctx context.Context, name string, kind int, remote *spanParent) (context.Context, *span) {
+	if tracer == nil {
+		return ctx, nil
+	}
+
+	s := &span{name: name, kind: kind, start: time.Now(), attributes: map[string]string{}}
+	switch parent := spanFrom(ctx); {
+	case parent != nil:
+		s.traceID, s.parentID = parent.traceID, parent.spanID
+	case remote != nil:
+		s.traceID, s.parentID = remote.traceID, remote.spanID
+	default:
+		rand.Read(s.traceID[:])
+	}
+	rand.Read(s.spanID[:])
+
+	return context.WithValue(ctx, spanKey, s), s
+}
+
+func (s *span) setAttribute(key, value string) {
+	if s != nil {
+		s.attributes[key] = value
+	}
+}
+
+// end finishes the span, marks it as failed if err is not nil and hands it to the exporter
+func (s *span) end(err error) {
+	if s == nil {
+		return
+	}
+	s.finish = time.Now()
+	if err != nil {
+		s.err = err.Error()
+	}
+	tracer.enqueue(s)
+}
+
+func (s *span) traceIDString() string {
+	return hex.EncodeToString(s.traceID[:])
+}
+
+// parseTraceParent reads a W3C traceparent header of the form 00-<trace id>-<parent id>-<flags>
+func parseTraceParent(header string) *spanParent {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) != 4 || parts[0] != "00" || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return nil
+	}
+
+	var parent spanParent
+	if _, err := hex.Decode(parent.traceID[:], []byte(parts[1])); err != nil {
+		return nil
+	}
+	if _, err := hex.Decode(parent.spanID[:], []byte(parts[2])); err != nil {
+		return nil
+	}
+	if parent.traceID == [16]byte{} || parent.spanID == [8]byte{} {
+		return nil
+	}
+
+	return &parent
+}
+
+// otlpExporter sends spans in batches to an OpenTelemetry collector using OTLP over HTTP with JSON encoding.
+// Spans are dropped if the queue is full, tracing never blocks a request.
+type otlpExporter struct {
+	url         string
+	serviceName string
+	client      *http.Client
+	queue       chan *span
+	stop        chan struct{}
+	stopped     sync.WaitGroup
+}
+
+// newOTLPExporter starts exporting spans to the collector at endpoint, e.g. http://otel-collector:4318
+func newOTLPExporter(endpoint, serviceName string) *otlpExporter {
+	if serviceName == "" {
+		serviceName = defaultServiceName
+	}
+	e := &otlpExporter{
+		url:         strings.TrimSuffix(endpoint, "/") + otlpTracesPath,
+		serviceName: serviceName,
+		client:      &http.Client{Timeout: spanExportTimeout},
+		queue:       make(chan *span, spanQueueSize),
+		stop:        make(chan struct{}),
+	}
+	e.stopped.Add(1)
+	go e.run()
+
+	return e
+}
+
+func (e *otlpExporter) enqueue(s *span) {
+	select {
+	case e.queue <- s:
+	default:
+	}
+}
+
+func (e *otlpExporter) run() {
+	defer e.stopped.Done()
+	ticker := time.NewTicker(spanExportInterval)
+	defer ticker.Stop()
+
+	var batch []*span
+	for {
+		select {
+		case s := <-e.queue:
+			batch = append(batch, s)
+			if len(batch) < spanBatchSize {
+				continue
+			}
+		case <-ticker.C:
+		case <-e.stop:
+			for len(e.queue) > 0 {
+				batch = append(batch, <-e.queue)
+			}
+			e.export(batch)
+			return
+		}
+		e.export(batch)
+		batch = nil
+	}
+}
+
+// shutdown exports the queued spans and stops the exporter
+func (e *otlpExporter) shutdown() {
+	close(e.stop)
+	e.stopped.Wait()
+}
+
+func (e *otlpExporter) export(spans []*span) {
+	if len(spans) == 0 {
+		return
+	}
+	body, err := json.Marshal(e.request(spans))
+	if err != nil {
+		slog.Error("could not encode spans", "error", err)
+		return
+	}
+	response, err := e.client.Post(e.url, otlpJSONContentType, bytes.NewReader(body))
+	if err != nil {
+		slog.Warn("could not export spans", "spans", len(spans), "error", err)
+		return
+	}
+	response.Body.Close()
+	if response.StatusCode >= 300 {
+		slog.Warn("could not export spans", "spans", len(spans), "status", response.StatusCode)
+	}
+}
+
+// request builds an ExportTraceServiceRequest in the JSON encoding of the OpenTelemetry protocol
+func (e *otlpExporter) request(spans []*span) map[string]interface{} {
+	otlpSpans := make([]map[string]interface{}, len(spans))
+	for i, s := range spans {
+		otlpSpan := map[string]interface{}{
+			"traceId":           hex.EncodeToString(s.traceID[:]),
+			"spanId":            hex.EncodeToString(s.spanID[:]),
+			"name":              s.name,
+			"kind":              s.kind,
+			"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(s.finish.UnixNano(), 10),
+			"attributes":        otlpAttributes(s.attributes),
+			"status":            map[string]interface{}{"code": spanStatusOK},
+		}
+		if s.parentID != [8]byte{} {
+			otlpSpan["parentSpanId"] = hex.EncodeToString(s.parentID[:])
+		}
+		if s.err != "" {
+			otlpSpan["status"] = map[string]interface{}{"code": spanStatusError, "message": s.err}
+		}
+		otlpSpans[i] = otlpSpan
+	}
+
+	return map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": otlpAttributes(map[string]string{"service.name": e.serviceName, "service.version": version}),
+			},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]interface{}{"name": defaultServiceName},
+				"spans": otlpSpans,
+			}},
+		}},
+	}
+}
+
+func otlpAttributes(attributes map[string]string) []interface{} {
+	result := make([]interface{}, 0, len(attributes))
+	for key, value := range attributes {
+		result = append(result, map[string]interface{}{
+			"key":   key,
+			"value": map[string]interface{}{"stringValue": value},
+		})
+	}
+
+	return result
+}
+
+// setupTracing starts exporting spans if an OTLP endpoint is configured
+func setupTracing(endpoint, serviceName string) {
+	if endpoint == "" {
+		return
+	}
+	tracer = newOTLPExporter(endpoint, serviceName)
+	slog.Info("exporting traces", "endpoint", tracer.url, "service", tracer.serviceName)
+}
+
+// shutdownTracing exports the remaining spans
+func shutdownTracing() {
+	if tracer != nil {
+		tracer.shutdown()
+	}
+}
@@ -0,0 +1,561 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	v2Prefix = "/v2"
+
+	defaultPageLimit = 100
+	maxPageLimit     = 1000
+)
+
+// apiRoute describes a route of the v2 API. The router, the roles allowed to call the route and the OpenAPI document
+// are all derived from these descriptions, so that the document cannot diverge from the code.
+type apiRoute struct {
+	method      string
+	path        string
+	operationID string
+	summary     string
+	handler     http.HandlerFunc
+	// roles besides admin that may call the route, adminOnly if there are none
+	roles []string
+	// query parameters, the path parameters are taken from the path
+	query []apiParameter
+	// request is the model of the body, nil if the route takes no body
+	request interface{}
+	// response is the model answered with 200 OK, nil if the route answers with the response envelope
+	response interface{}
+}
+
+// apiParameter is a query parameter of a route, its kind is string, integer, number or boolean
+type apiParameter struct {
+	name        string
+	kind        string
+	description string
+}
+
+var pageParameters = []apiParameter{
+	{"offset", "integer", "number of items to skip"},
+	{"limit", "integer", fmt.Sprintf("maximum number of items, %d by default and at most %d", defaultPageLimit, maxPageLimit)},
+}
+
+// dateRangeParameters select reviews and pages by their date, e.g. 20191101
+var dateRangeParameters = []apiParameter{
+	{"from", "integer", "earliest date of the items like 20191101"},
+	{"to", "integer", "latest date of the items like 20191101, no limit if it is 0"},
+}
+
+// timeRangeParameters select audit entries by their unix time
+var timeRangeParameters = []apiParameter{
+	{"from", "integer", "earliest unix time of the items"},
+	{"to", "integer", "latest unix time of the items, no limit if it is 0"},
+}
+
+// v2Routes returns the routes of the v2 API, their paths are relative to /v2
+func v2Routes() []apiRoute {
+	var reviewParameters []apiParameter
+	reviewParameters = append(reviewParameters,
+		apiParameter{"class", "string", "only reviews labeled with the class, e.g. bug_report or feature_request"},
+		apiParameter{"min_confidence", "number", fmt.Sprintf("minimum confidence of the class label, %g by default", labelConfidenceThreshold)},
+		apiParameter{"exclude_duplicates", "boolean", "leave out the reviews repeating another review"},
+	)
+	reviewParameters = append(reviewParameters, dateRangeParameters...)
+	reviewParameters = append(reviewParameters, pageParameters...)
+
+	auditParameters := []apiParameter{
+		{"action", "string", "only entries of the action, e.g. app_reviews.store or observable.delete"},
+		{"client", "string", "only entries of the client, tenant/name or the address of unauthenticated clients"},
+		{"key", "string", "only entries of writes affecting the key, e.g. a package name or review id"},
+	}
+	auditParameters = append(auditParameters, timeRangeParameters...)
+	auditParameters = append(auditParameters, pageParameters...)
+
+	return []apiRoute{
+		{
+			method:      "GET",
+			path:        "/apps/{package_name}/reviews",
+			operationID: "listAppReviews",
+			summary:     "List the reviews of an app, newest first.",
+			handler:     getV2AppReviews,
+			roles:       []string{roleAnalyst},
+			query:       reviewParameters,
+			response:    []AppReviewGooglePlay{},
+		},
+		{
+			method:      "POST",
+			path:        "/apps/{package_name}/reviews",
+			operationID: "storeAppReviews",
+			summary:     "Store reviews of an app, reviews without package name get the one of the path.",
+			handler:     postV2AppReviews,
+			roles:       []string{roleCrawler},
+			request:     []AppReviewGooglePlay{},
+		},
+		{
+			method:      "GET",
+			path:        "/apps/{package_name}/pages",
+			operationID: "listAppPages",
+			summary:     "List the crawled pages of an app, newest first.",
+			handler:     getV2AppPages,
+			roles:       []string{roleAnalyst},
+			query:       append(append([]apiParameter{}, dateRangeParameters...), pageParameters...),
+			response:    []AppPageGooglePlay{},
+		},
+		{
+			method:      "POST",
+			path:        "/apps/{package_name}/pages",
+			operationID: "storeAppPage",
+			summary:     "Store a crawled page of an app, a page without package name gets the one of the path.",
+			handler:     postV2AppPage,
+			roles:       []string{roleCrawler},
+			request:     AppPageGooglePlay{},
+		},
+		{
+			method:      "GET",
+			path:        "/observables",
+			operationID: "listObservables",
+			summary:     "List the observed apps.",
+			handler:     getV2Observables,
+			roles:       []string{roleCrawler, roleAnalyst},
+			response:    []ObservableGooglePlay{},
+		},
+		// observing apps changes what the crawlers crawl, it is reserved to admins like in the original API
+		{
+			method:      "PUT",
+			path:        "/observables/{package_name}",
+			operationID: "putObservable",
+			summary:     "Observe an app or change the interval of an observed app.",
+			handler:     putV2Observable,
+			roles:       adminOnly,
+			request:     ObservableGooglePlay{},
+			response:    ObservableGooglePlay{},
+		},
+		{
+			method:      "DELETE",
+			path:        "/observables/{package_name}",
+			operationID: "deleteObservable",
+			summary:     "Stop observing an app.",
+			handler:     deleteV2Observable,
+			roles:       adminOnly,
+		},
+		{
+			method:      "POST",
+			path:        "/erasures",
+			operationID: "eraseAuthor",
+			summary:     "Delete the reviews of an author or remove the author from them, the erasure is recorded without the author.",
+			handler:     postV2Erasure,
+			roles:       adminOnly,
+			request:     ErasureRequest{},
+			response:    ErasureGooglePlay{},
+		},
+		{
+			method:      "GET",
+			path:        "/erasures",
+			operationID: "listErasures",
+			summary:     "List the recorded erasures, newest first.",
+			handler:     getV2Erasures,
+			roles:       adminOnly,
+			query:       pageParameters,
+			response:    []ErasureGooglePlay{},
+		},
+		{
+			method:      "GET",
+			path:        "/audit",
+			operationID: "listAuditEntries",
+			summary:     "List the audit entries of the writes, newest first.",
+			handler:     getV2AuditEntries,
+			roles:       adminOnly,
+			query:       auditParameters,
+			response:    []AuditEntryGooglePlay{},
+		},
+		{
+			method:      "GET",
+			path:        "/openapi.json",
+			operationID: "getOpenAPIDocument",
+			summary:     "The OpenAPI 3 document of the v2 API.",
+			handler:     getV2OpenAPIDocument,
+			roles:       []string{roleCrawler, roleAnalyst},
+			response:    map[string]interface{}{},
+		},
+	}
+}
+
+// v2RouteRoles lists the roles of the v2 routes by method and path
+var v2RouteRoles = apiRouteRoles(v2Routes())
+
+func apiRouteRoles(routes []apiRoute) map[string][]string {
+	roles := map[string][]string{}
+	for _, route := range routes {
+		roles[route.method+" "+v2Prefix+route.path] = route.roles
+	}
+
+	return roles
+}
+
+// appReviewFilter selects a page of the reviews of a package
+type appReviewFilter struct {
+	packageName       string
+	class             string
+	minConfidence     float64
+	excludeDuplicates bool
+	from              int64
+	to                int64
+	offset            int
+	limit             int
+}
+
+// queryDateRange returns the from and to query parameters of reviews and pages, dates like 20191101, and collects the
+// invalid ones
+func queryDateRange(r *http.Request, v *validator) (from, to int64) {
+	from, err := queryInt64(r, "from", 0)
+	v.check(err == nil, "from", "must be a date like 20191101")
+	v.dateBound(from, "from")
+	to, err = queryInt64(r, "to", 0)
+	v.check(err == nil, "to", "must be a date like 20191101")
+	v.dateBound(to, "to")
+
+	return from, to
+}
+
+// queryTimeRange returns the from and to query parameters of audit entries, unix times, and collects the invalid ones
+func queryTimeRange(r *http.Request, v *validator) (from, to int64) {
+	from, err := queryInt64(r, "from", 0)
+	v.check(err == nil && from >= 0, "from", "must be a unix time")
+	to, err = queryInt64(r, "to", 0)
+	v.check(err == nil && to >= 0, "to", "must be a unix time")
+
+	return from, to
+}
+
+// queryPage returns the offset and limit query parameters and collects the invalid ones
+func queryPage(r *http.Request, v *validator) (offset, limit int) {
+	offset, err := queryInt(r, "offset", 0)
+	v.check(err == nil && offset >= 0, "offset", "must be a number of items to skip")
+	limit, err = queryInt(r, "limit", defaultPageLimit)
+	v.check(err == nil && limit >= 1 && limit <= maxPageLimit, "limit", "must be between 1 and %d", maxPageLimit)
+
+	return offset, limit
+}
+
+func getV2AppReviews(w http.ResponseWriter, r *http.Request) {
+	// get request param
+	v := validator{}
+	filter := appReviewFilter{packageName: mux.Vars(r)["package_name"], class: r.URL.Query().Get("class")}
+	v.packageName(filter.packageName, "package_name", true)
+	var err error
+	filter.minConfidence, err = queryFloat(r, "min_confidence", labelConfidenceThreshold)
+	v.check(err == nil, "min_confidence", "must be a number")
+	filter.excludeDuplicates, err = queryBool(r, "exclude_duplicates", false)
+	v.check(err == nil, "exclude_duplicates", "must be true or false")
+	filter.from, filter.to = queryDateRange(r, &v)
+	filter.offset, filter.limit = queryPage(r, &v)
+	if len(v.errs) > 0 {
+		writeValidationErrors(w, r, v.errs)
+		return
+	}
+
+	// query db
+	m, release := requestSession(r)
+	defer release()
+	reviews, ok := MongoFindAppReviewsGooglePlay(m, filter)
+
+	// send response
+	if !ok {
+		writeStorageError(w, r, "could not read the app reviews")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(reviews)
+}
+
+func postV2AppReviews(w http.ResponseWriter, r *http.Request) {
+	// get data from the request
+	packageName := mux.Vars(r)["package_name"]
+	var appReviews []AppReviewGooglePlay
+	err := json.NewDecoder(r.Body).Decode(&appReviews)
+	if err != nil {
+		loggerFrom(r.Context()).Warn("invalid request body", "error", err)
+		writeDecodeError(w, r, err)
+		return
+	}
+	if exceedsMaxArrayLength(len(appReviews)) {
+		loggerFrom(r.Context()).Warn("too many app reviews", "app_reviews", len(appReviews), "max", config.Limits.MaxArrayLength)
+		writeArrayTooLong(w, r)
+		return
+	}
+	var errs validationErrors
+	for i := range appReviews {
+		if appReviews[i].PackageName == "" {
+			appReviews[i].PackageName = packageName
+		}
+		v := validator{prefix: fmt.Sprintf("[%d].", i)}
+		v.check(appReviews[i].PackageName == packageName, "package_name", "must be %s like the path", packageName)
+		errs = append(errs, v.errs...)
+	}
+	if errs = append(validateAppReviews(appReviews), errs...); len(errs) > 0 {
+		writeValidationErrors(w, r, errs)
+		return
+	}
+
+	// insert data into the db
+	m, release := requestSession(r)
+	defer release()
+	inserted, updated, ok := storeAppReviews(m, tenantFrom(r.Context()), appReviews)
+	auditAppReviews(r, m, appReviews, inserted, updated, ok)
+
+	// send response
+	if ok {
+		writeResponse(w, fmt.Sprintf("%d app reviews stored", len(appReviews)))
+	} else {
+		writeStorageError(w, r, "could not store the app reviews")
+	}
+}
+
+func getV2AppPages(w http.ResponseWriter, r *http.Request) {
+	// get request param
+	v := validator{}
+	packageName := mux.Vars(r)["package_name"]
+	v.packageName(packageName, "package_name", true)
+	from, to := queryDateRange(r, &v)
+	offset, limit := queryPage(r, &v)
+	if len(v.errs) > 0 {
+		writeValidationErrors(w, r, v.errs)
+		return
+	}
+
+	// query db
+	m, release := requestSession(r)
+	defer release()
+	appPages, ok := MongoFindAppPagesGooglePlay(m, packageName, from, to, offset, limit)
+
+	// send response
+	if !ok {
+		writeStorageError(w, r, "could not read the app pages")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(appPages)
+}
+
+func postV2AppPage(w http.ResponseWriter, r *http.Request) {
+	// get data from the request
+	packageName := mux.Vars(r)["package_name"]
+	var appPage AppPageGooglePlay
+	err := json.NewDecoder(r.Body).Decode(&appPage)
+	if err != nil {
+		loggerFrom(r.Context()).Warn("invalid request body", "error", err)
+		writeDecodeError(w, r, err)
+		return
+	}
+	if appPage.PackageName == "" {
+		appPage.PackageName = packageName
+	}
+	errs := validateAppPage(appPage)
+	if appPage.PackageName != packageName {
+		errs = append(errs, FieldError{Field: "package_name", Message: "must be " + packageName + " like the path"})
+	}
+	if len(errs) > 0 {
+		writeValidationErrors(w, r, errs)
+		return
+	}
+
+	// insert data into the db
+	m, release := requestSession(r)
+	defer release()
+	isNew, ok := MongoInsertAppPageGooglePlay(m, appPage)
+	if isNew {
+		notifyAppPageSubscribers(m, appPage)
+	}
+	auditAppPage(r, m, appPage, isNew, ok)
+
+	// send response
+	if ok {
+		writeResponse(w, "app page stored")
+	} else {
+		writeStorageError(w, r, "could not store the app page")
+	}
+}
+
+func getV2Observables(w http.ResponseWriter, r *http.Request) {
+	// query db
+	m, release := requestSession(r)
+	defer release()
+	observables := MongoGetAllObservableGooglePlay(m)
+	if observables == nil {
+		observables = []ObservableGooglePlay{}
+	}
+
+	// send response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(observables)
+}
+
+func putV2Observable(w http.ResponseWriter, r *http.Request) {
+	// get data from the request
+	packageName := mux.Vars(r)["package_name"]
+	var observable ObservableGooglePlay
+	err := json.NewDecoder(r.Body).Decode(&observable)
+	if err != nil {
+		loggerFrom(r.Context()).Warn("invalid request body", "error", err)
+		writeDecodeError(w, r, err)
+		return
+	}
+	if observable.PackageName == "" {
+		observable.PackageName = packageName
+	}
+	errs := validateObservable(observable)
+	if observable.PackageName != packageName {
+		errs = append(errs, FieldError{Field: "package_name", Message: "must be " + packageName + " like the path"})
+	}
+	if len(errs) > 0 {
+		writeValidationErrors(w, r, errs)
+		return
+	}
+
+	// insert data into the db
+	m, release := requestSession(r)
+	defer release()
+	isNew, ok := MongoUpsertObservableGooglePlay(m, observable)
+	counts := map[string]int{"inserted": 0, "updated": 0}
+	switch {
+	case isNew:
+		counts["inserted"] = 1
+	case ok:
+		counts["updated"] = 1
+	}
+	audit(r, m, auditKey(packageName), AuditEntryGooglePlay{Action: auditStoreObservable, Counts: counts, Failed: !ok})
+
+	// send response
+	if !ok {
+		writeStorageError(w, r, "could not store the observable")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(observable)
+}
+
+func deleteV2Observable(w http.ResponseWriter, r *http.Request) {
+	// get request param
+	packageName := mux.Vars(r)["package_name"]
+
+	// delete data from the db
+	m, release := requestSession(r)
+	defer release()
+	found, ok := MongoDeleteObservableGooglePlay(m, packageName)
+	audit(r, m, auditKey(packageName), AuditEntryGooglePlay{Action: auditDeleteObservable, Counts: deletedCount(found), Failed: !ok})
+
+	// send response
+	if !ok {
+		writeStorageError(w, r, "could not delete the observable")
+	} else if !found {
+		writeError(w, r, http.StatusNotFound, errorCodeNotFound, packageName+" is not observed", nil)
+	} else {
+		writeResponse(w, "observable deleted")
+	}
+}
+
+func postV2Erasure(w http.ResponseWriter, r *http.Request) {
+	// get data from the request
+	var request ErasureRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		loggerFrom(r.Context()).Warn("invalid request body", "error", err)
+		writeDecodeError(w, r, err)
+		return
+	}
+	if errs := validateErasureRequest(request); len(errs) > 0 {
+		writeValidationErrors(w, r, errs)
+		return
+	}
+
+	// erase data in the db
+	m, release := requestSession(r)
+	defer release()
+	erasure, ok := eraseAuthor(m, request, clientKey(r), time.Now())
+	audit(r, m, auditKey(erasure.ErasureID), AuditEntryGooglePlay{
+		Action: auditEraseAuthor,
+		Counts: map[string]int{"app_reviews": erasure.AppReviews, "outbox_events": erasure.OutboxEvents},
+		Failed: !ok,
+	})
+
+	// send response
+	if !ok {
+		writeStorageError(w, r, "could not erase the author")
+		return
+	}
+	loggerFrom(r.Context()).Info("author erased", "erasure_id", erasure.ErasureID, "mode", erasure.Mode, "app_reviews", erasure.AppReviews)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(erasure)
+}
+
+func getV2Erasures(w http.ResponseWriter, r *http.Request) {
+	// get request param
+	v := validator{}
+	offset, limit := queryPage(r, &v)
+	if len(v.errs) > 0 {
+		writeValidationErrors(w, r, v.errs)
+		return
+	}
+
+	// query db
+	m, release := requestSession(r)
+	defer release()
+	erasures, ok := MongoGetErasuresGooglePlay(m, offset, limit)
+
+	// send response
+	if !ok {
+		writeStorageError(w, r, "could not read the erasures")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(erasures)
+}
+
+func getV2AuditEntries(w http.ResponseWriter, r *http.Request) {
+	// get request param
+	v := validator{}
+	filter := auditFilter{
+		action: r.URL.Query().Get("action"),
+		client: r.URL.Query().Get("client"),
+		key:    r.URL.Query().Get("key"),
+	}
+	filter.from, filter.to = queryTimeRange(r, &v)
+	filter.offset, filter.limit = queryPage(r, &v)
+	if len(v.errs) > 0 {
+		writeValidationErrors(w, r, v.errs)
+		return
+	}
+
+	// query db
+	m, release := requestSession(r)
+	defer release()
+	entries, ok := MongoFindAuditEntriesGooglePlay(m, filter)
+
+	// send response
+	if !ok {
+		writeStorageError(w, r, "could not read the audit entries")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(entries)
+}
+
+func getV2OpenAPIDocument(w http.ResponseWriter, r *http.Request) {
+	// send response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(openAPIDocument(v2Routes()))
+}
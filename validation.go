@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// maxDateSkew is how far dates may lie in the future, e.g. because of the time zone of a crawler
+	maxDateSkew = 24 * time.Hour
+
+	// dateLayout formats the dates of reviews and app pages, which are stored as integers like 20191101
+	dateLayout = "20060102"
+)
+
+// packageNamePattern matches Android package names like com.example.app
+var packageNamePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*(\.[A-Za-z][A-Za-z0-9_]*)+$`)
+
+// validationErrors lists the invalid fields of a request
+type validationErrors []FieldError
+
+func (errs validationErrors) Error() string {
+	messages := make([]string, len(errs))
+	for i, err := range errs {
+		messages[i] = err.Field + ": " + err.Message
+	}
+
+	return strings.Join(messages, "; ")
+}
+
+// validator collects the field errors of a model, prefixed with the position of the model in a request
+type validator struct {
+	prefix string
+	errs   validationErrors
+}
+
+func (v *validator) check(ok bool, field, format string, a ...interface{}) {
+	if !ok {
+		v.errs = append(v.errs, FieldError{Field: v.prefix + field, Message: fmt.Sprintf(format, a...)})
+	}
+}
+
+func (v *validator) required(value, field string) bool {
+	v.check(strings.TrimSpace(value) != "", field, "is required")
+	return strings.TrimSpace(value) != ""
+}
+
+func (v *validator) packageName(packageName, field string, required bool) {
+	if packageName == "" && !required {
+		return
+	}
+	if v.required(packageName, field) {
+		v.check(packageNamePattern.MatchString(packageName), field, "%q is not a package name like com.example.app", packageName)
+	}
+}
+
+// date accepts calendar dates like 20191101 that are not in the future, 0 means unknown
+func (v *validator) date(d int64, field string) {
+	if d == 0 {
+		return
+	}
+	t, ok := parseDate(d)
+	v.check(ok && !t.After(time.Now().Add(maxDateSkew)), field, "%d is not a date like 20191101 that is not in the future", d)
+}
+
+// dateBound accepts a bound of a date range, a date like 20191101 or 0 if the range is open
+func (v *validator) dateBound(d int64, field string) {
+	_, ok := parseDate(d)
+	v.check(d == 0 || ok, field, "%d is not a date like 20191101", d)
+}
+
+// parseDate returns the day of a date like 20191101, ok is false if it is no calendar date
+func parseDate(d int64) (t time.Time, ok bool) {
+	t, err := time.Parse(dateLayout, strconv.FormatInt(d, 10))
+	return t, err == nil
+}
+
+// dateOf returns the date of the day of the time, e.g. 20191101
+func dateOf(t time.Time) int64 {
+	d, _ := strconv.ParseInt(t.Format(dateLayout), 10, 64)
+	return d
+}
+
+func (v *validator) httpURL(value, field string) bool {
+	if value == "" {
+		return false
+	}
+	u, err := url.Parse(value)
+	ok := err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+	v.check(ok, field, "%q is not an http or https url", value)
+
+	return ok
+}
+
+// webhookURL accepts http or https urls of hosts that are not internal, unless they are allowed by the config
+func (v *validator) webhookURL(value, field string) {
+	if v.httpURL(value, field) {
+		err := checkWebhookURL(value)
+		v.check(err == nil, field, "%v, internal hosts must be listed in webhooks.allowed_hosts", err)
+	}
+}
+
+func (v *validator) notNegative(n float64, field string) {
+	v.check(n >= 0, field, "must not be negative")
+}
+
+// validateAppReview returns the field errors of a review, prefixed with e.g. its index in a request
+func validateAppReview(review AppReviewGooglePlay, prefix string) validationErrors {
+	v := validator{prefix: prefix}
+	v.required(review.ReviewID, "review_id")
+	v.packageName(review.PackageName, "package_name", true)
+	v.check(review.Rating >= 1 && review.Rating <= 5, "rating", "%d is not between 1 and 5", review.Rating)
+	v.date(review.Date, "date_posted")
+	v.httpURL(review.PermaLink, "perma_link")
+	for i, label := range review.Labels {
+		v.required(label.Name, fmt.Sprintf("labels[%d].name", i))
+		v.check(label.Confidence >= 0 && label.Confidence <= 1, fmt.Sprintf("labels[%d].confidence", i), "%g is not between 0 and 1", label.Confidence)
+	}
+
+	return v.errs
+}
+
+// validateAppReviews returns the field errors of all reviews of a request
+func validateAppReviews(reviews []AppReviewGooglePlay) validationErrors {
+	var errs validationErrors
+	for i, review := range reviews {
+		errs = append(errs, validateAppReview(review, fmt.Sprintf("[%d].", i))...)
+	}
+
+	return errs
+}
+
+func validateAppPage(appPage AppPageGooglePlay) validationErrors {
+	v := validator{}
+	v.packageName(appPage.PackageName, "package_name", true)
+	v.date(appPage.DateCrawled, "date_crawled")
+	v.date(appPage.LastUpdate, "last_update")
+	v.check(appPage.Rating >= 0 && appPage.Rating <= 5, "rating", "%g is not between 0 and 5", appPage.Rating)
+	v.notNegative(appPage.PriceValue, "price_value")
+	v.notNegative(float64(appPage.StarsCount), "stars_count")
+	v.notNegative(float64(appPage.EstimatedDownloadNumber), "estimated_download_number")
+	for stars, count := range []int{
+		appPage.CountPerRating.One,
+		appPage.CountPerRating.Two,
+		appPage.CountPerRating.Three,
+		appPage.CountPerRating.Four,
+		appPage.CountPerRating.Five,
+	} {
+		v.notNegative(float64(count), fmt.Sprintf("count_per_rating.%d", stars+1))
+	}
+
+	return v.errs
+}
+
+func validateObservable(observable ObservableGooglePlay) validationErrors {
+	v := validator{}
+	v.packageName(observable.PackageName, "package_name", true)
+	v.required(observable.Interval, "interval")
+
+	return v.errs
+}
+
+func validateAlertRule(rule AlertRuleGooglePlay) validationErrors {
+	v := validator{}
+	v.packageName(rule.PackageName, "package_name", true)
+	v.check(isValidAlertType(rule.Type), "type", "%q is not an alert type", rule.Type)
+	v.check(rule.Class == "" || legacyClassFields[rule.Class] != "", "class", "%q is not bug_report or feature_request", rule.Class)
+	v.notNegative(rule.Threshold, "threshold")
+	v.notNegative(float64(rule.MinCount), "min_count")
+	v.notNegative(float64(rule.WindowDays), "window_days")
+	v.notNegative(float64(rule.BaselineDays), "baseline_days")
+	v.webhookURL(rule.WebhookURL, "webhook_url")
+
+	return v.errs
+}
+
+func validateSubscription(subscription SubscriptionGooglePlay) validationErrors {
+	v := validator{}
+	if v.required(subscription.URL, "url") {
+		v.webhookURL(subscription.URL, "url")
+	}
+	for i, event := range subscription.Events {
+		v.check(isValidEvent(event), fmt.Sprintf("events[%d]", i), "%q is not app_review or app_page", event)
+	}
+	v.packageName(subscription.PackageName, "package_name", false)
+	v.check(subscription.Class == "" || legacyClassFields[subscription.Class] != "", "class", "%q is not bug_report or feature_request", subscription.Class)
+	v.check(subscription.MinRating >= 0 && subscription.MinRating <= 5, "min_rating", "%d is not between 0 and 5", subscription.MinRating)
+
+	return v.errs
+}
+
+// writeValidationErrors answers 400 Bad Request listing the invalid fields
+func writeValidationErrors(w http.ResponseWriter, r *http.Request, errs validationErrors) {
+	loggerFrom(r.Context()).Warn("invalid request", "path", r.URL.Path, "errors", errs.Error())
+	writeError(w, r, http.StatusBadRequest, errorCodeValidation, "invalid request: "+errs.Error(), errs)
+}
+
+func validateErasureRequest(request ErasureRequest) validationErrors {
+	v := validator{}
+	v.required(request.Author, "author")
+	v.check(request.Mode == "" || request.Mode == erasureDelete || request.Mode == erasureRedact, "mode", "%q is not delete or redact", request.Mode)
+
+	return v.errs
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// webhookSignatureHeader carries the hex encoded HMAC-SHA256 of the request body keyed with the subscription secret
+const webhookSignatureHeader = "X-Signature-256"
+
+var webhookClient = &http.Client{
+	Timeout:   10 * time.Second,
+	Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, DialContext: dialWebhook},
+}
+
+// sharedAddressSpace is the carrier-grade NAT range 100.64.0.0/10, which clusters use for internal addresses as well
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// dialWebhook connects to the host of a webhook and refuses internal addresses of hosts that are not allowed. The
+// resolved address is checked, so that neither a redirect nor a host resolving differently after validation can
+// reach internal services.
+func dialWebhook(ctx context.Context, network, address string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(_, resolved string, _ syscall.RawConn) error {
+			ip, _, err := net.SplitHostPort(resolved)
+			if err != nil {
+				return err
+			}
+			return checkWebhookAddress(host, net.ParseIP(strings.SplitN(ip, "%", 2)[0]))
+		},
+	}
+
+	return dialer.DialContext(ctx, network, address)
+}
+
+// checkWebhookURL resolves the host of a webhook url and fails if it is internal and not allowed
+func checkWebhookURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	host := u.Hostname()
+	if webhookHostAllowed(host) {
+		return nil
+	}
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return fmt.Errorf("host %s cannot be resolved", host)
+	}
+	for _, ip := range ips {
+		if err = checkWebhookAddress(host, ip); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkWebhookAddress fails if the address of a webhook host is internal and neither the host nor the address is
+// allowed
+func checkWebhookAddress(host string, ip net.IP) error {
+	if ip == nil {
+		return fmt.Errorf("host %s has no ip address", host)
+	}
+	if isInternalIP(ip) && !webhookHostAllowed(host) && !webhookHostAllowed(ip.String()) {
+		return fmt.Errorf("host %s has the internal address %s", host, ip)
+	}
+
+	return nil
+}
+
+// isInternalIP reports whether the address is a loopback, private, link-local, unspecified or shared address, which
+// are not reachable from the internet
+func isInternalIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsUnspecified() || sharedAddressSpace.Contains(ip)
+}
+
+// webhookHostAllowed reports whether the host name or address is listed in webhooks.allowed_hosts, by name, by
+// address or by a network like 10.0.0.0/8
+func webhookHostAllowed(host string) bool {
+	ip := net.ParseIP(host)
+	for _, allowed := range config.Webhooks.AllowedHosts {
+		if _, network, err := net.ParseCIDR(allowed); err == nil {
+			if ip != nil && network.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		if strings.EqualFold(allowed, host) || (ip != nil && ip.Equal(net.ParseIP(allowed))) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// postWebhook sends the payload as json to the given url and fails if the receiver does not answer with a 2xx status
+func postWebhook(url string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	_, err = sendWebhook(url, "", body)
+	return err
+}
+
+// sendWebhook posts the json body to the given url, signed if a secret is given, and returns the response status
+func sendWebhook(url, secret string, body []byte) (int, error) {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if secret != "" {
+		req.Header.Set(webhookSignatureHeader, signWebhookBody(secret, body))
+	}
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("webhook %s answered with status %d", url, resp.StatusCode)
+	}
+
+	return resp.StatusCode, nil
+}
+
+// signWebhookBody returns the signature header value of a webhook body
+func signWebhookBody(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}